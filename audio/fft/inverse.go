@@ -0,0 +1,192 @@
+package fft
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// colaTolerance is the relative ripple allowed in the overlap-added window sum for it to
+// still be considered constant.
+const colaTolerance = 1e-6
+
+// CheckCOLA reports whether copies of the window @w shifted by @hop samples sum to a
+// constant (the constant overlap-add condition) and returns that constant. Spectral
+// processing only resynthesizes without modulation artifacts when this holds.
+func CheckCOLA(w []float64, hop int) (float64, error) {
+	sum, err := overlapSum(w, hop)
+	if err != nil {
+		return 0, err
+	}
+	min, max := sum[0], sum[0]
+	for _, s := range sum {
+		min = math.Min(min, s)
+		max = math.Max(max, s)
+	}
+	mean := (min + max) / 2
+	if ripple := (max - min) / mean; ripple > colaTolerance {
+		return mean, fmt.Errorf("window is not COLA for hop %d: ripple %.3g", hop, ripple)
+	}
+	return mean, nil
+}
+
+// overlapSum returns the steady-state sum of windows spaced @hop samples apart for each
+// sample offset within a hop.
+func overlapSum(w []float64, hop int) ([]float64, error) {
+	if hop <= 0 || hop > len(w) {
+		return nil, fmt.Errorf("hop %d out of range for window of size %d", hop, len(w))
+	}
+	sum := make([]float64, hop)
+	for i, v := range w {
+		sum[i%hop] += v
+	}
+	for _, s := range sum {
+		if s <= 0 {
+			return nil, errors.New("window sum vanishes; signal can not be reconstructed")
+		}
+	}
+	return sum, nil
+}
+
+// InverseFFTProcessor turns the half spectra output by FFTProcessor back into audio using
+// weighted overlap-add. Each output sample is divided by the sum of the analysis windows
+// that covered it, so the round trip of an unchanged spectrum is exact even when the window
+// isn't COLA for the hop. A spectrum that's changed on the way, by a gate or a filter, only
+// comes back without modulation at the hop rate when the window is COLA, which is checked
+// when the processor is created.
+//
+// FFTProcessor drops the Nyquist bin, which is assumed to be zero here; signals with
+// energy right at the Nyquist frequency will not come back exactly.
+type InverseFFTProcessor struct {
+	Size int
+	Hop  int
+	// COLA is whether the window meets the constant overlap-add condition for Hop.
+	COLA bool
+
+	window []float64
+	// acc accumulates the overlapping frames and wacc the windows applied to them.
+	acc  []float64
+	wacc []float64
+}
+
+// NewInverseFFTProcessor creates an inverse processor for frames analyzed with window @w
+// that advance by @hop samples. It fails if the windows leave samples uncovered, and logs
+// when they aren't COLA.
+func NewInverseFFTProcessor(w []float64, hop int) (*InverseFFTProcessor, error) {
+	if _, err := overlapSum(w, hop); err != nil {
+		return nil, err
+	}
+	_, err := CheckCOLA(w, hop)
+	if err != nil {
+		log.Println("[INFO] inverse fft:", err)
+	}
+	return &InverseFFTProcessor{
+		Size:   len(w),
+		Hop:    hop,
+		COLA:   err == nil,
+		window: w,
+		acc:    make([]float64, len(w)),
+		wacc:   make([]float64, len(w)),
+	}, nil
+}
+
+// Inverse returns an InverseFFTProcessor that undoes this FFTProcessor for frames that
+// advance by @hop samples.
+func (f *FFTProcessor) Inverse(hop int) (*InverseFFTProcessor, error) {
+	return NewInverseFFTProcessor(f.window, hop)
+}
+
+// Synthesize adds the frame with half spectrum @Fx to the output and returns the next
+// Hop samples, which no later frame will overlap.
+func (p *InverseFFTProcessor) Synthesize(Fx []complex128) ([]float64, error) {
+	if len(Fx) != p.Size/2 {
+		return nil, fmt.Errorf("spectrum size %d does not match frame size %d", len(Fx), p.Size)
+	}
+
+	full := make([]complex128, p.Size)
+	copy(full, Fx)
+	for k := 1; k < p.Size/2; k++ {
+		full[p.Size-k] = cmplx.Conj(Fx[k])
+	}
+	x := fft.IFFT(full)
+
+	for i := range x {
+		p.acc[i] += real(x[i])
+		p.wacc[i] += p.window[i]
+	}
+
+	out := make([]float64, p.Hop)
+	for i := range out {
+		if p.wacc[i] > 1e-12 {
+			out[i] = p.acc[i] / p.wacc[i]
+		}
+	}
+
+	// shift the accumulators along by one hop
+	copy(p.acc, p.acc[p.Hop:])
+	copy(p.wacc, p.wacc[p.Hop:])
+	for i := p.Size - p.Hop; i < p.Size; i++ {
+		p.acc[i] = 0
+		p.wacc[i] = 0
+	}
+
+	return out, nil
+}
+
+// Flush returns the last Size-Hop samples, which the final frames overlap but no frame
+// completes, and clears the processor for a new signal.
+func (p *InverseFFTProcessor) Flush() []float64 {
+	out := make([]float64, p.Size-p.Hop)
+	for i := range out {
+		if p.wacc[i] > 1e-12 {
+			out[i] = p.acc[i] / p.wacc[i]
+		}
+	}
+	for i := range p.acc {
+		p.acc[i] = 0
+		p.wacc[i] = 0
+	}
+	return out
+}
+
+// Process kicks off a goroutine to resynthesize incoming @in spectra and returns a channel
+// of audio blocks of Hop samples. When @in closes, the tail that's left is flushed as a
+// final, shorter block. Spectra that can't be resynthesized are logged and skipped.
+func (p *InverseFFTProcessor) Process(done chan struct{}, in chan []complex128) chan []float64 {
+	out := make(chan []float64)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			Fx := <-in
+			if Fx == nil {
+				if tail := p.Flush(); len(tail) > 0 {
+					select {
+					case out <- tail:
+					case <-done:
+					}
+				}
+				return
+			}
+
+			x, err := p.Synthesize(Fx)
+			if err != nil {
+				log.Println("[ERROR] inverse fft:", err)
+				continue
+			}
+			out <- x
+		}
+	}()
+
+	return out
+}
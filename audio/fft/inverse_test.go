@@ -0,0 +1,86 @@
+package fft
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mjibson/go-dsp/window"
+)
+
+func TestCheckCOLA(t *testing.T) {
+	size := 512
+
+	// a periodic Hann window at 50% overlap sums to exactly 1
+	hann := make([]float64, size)
+	for i := range hann {
+		hann[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+	if c, err := CheckCOLA(hann, size/2); err != nil || math.Abs(c-1) > 1e-9 {
+		t.Error("expected periodic Hann to be COLA at 50% overlap:", c, err)
+	}
+	if inv, err := NewInverseFFTProcessor(hann, size/2); err != nil || !inv.COLA {
+		t.Error("expected the inverse processor to find Hann COLA:", err)
+	}
+
+	// the symmetric Hamming window used by FFTProcessor isn't COLA without overlap
+	if _, err := CheckCOLA(window.Hamming(size), size); err == nil {
+		t.Error("expected Hamming with no overlap to fail COLA")
+	}
+
+	if _, err := CheckCOLA(hann, size+1); err == nil {
+		t.Error("expected hop larger than the window to fail")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	size := 1024
+	sampleRate := 44100.0
+	signal := make([]float64, 16*size)
+	for i := range signal {
+		ts := float64(i) / sampleRate
+		signal[i] = 0.5*math.Sin(2*math.Pi*440*ts) + 0.25*math.Sin(2*math.Pi*3001*ts+1)
+	}
+
+	for _, hop := range []int{size, size / 2, size / 4} {
+		f := NewFFTProcessor(sampleRate, size)
+		inv, err := f.Inverse(hop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the symmetric Hamming window is only close to COLA
+		if inv.COLA {
+			t.Errorf("hop=%d: expected Hamming not to be COLA", hop)
+		}
+
+		done := make(chan struct{})
+		in := make(chan []float64)
+		out := inv.Process(done, f.Process(done, in))
+
+		go func() {
+			for i := 0; i+size <= len(signal); i += hop {
+				fx := make([]float64, size)
+				copy(fx, signal[i:i+size])
+				in <- fx
+			}
+			close(in)
+		}()
+
+		var y []float64
+		for x := range out {
+			y = append(y, x...)
+		}
+		close(done)
+
+		if len(y) != len(signal) {
+			t.Errorf("hop=%d: expected %d samples with the tail, got %d", hop, len(signal), len(y))
+		}
+		var maxErr float64
+		for i := range y {
+			maxErr = math.Max(maxErr, math.Abs(y[i]-signal[i]))
+		}
+		t.Logf("hop=%d max error=%g", hop, maxErr)
+		if maxErr > 1e-3 {
+			t.Errorf("hop=%d: round trip error %g too large", hop, maxErr)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package fft
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"time"
+
+	"github.com/mjibson/go-dsp/fft"
+	"github.com/mjibson/go-dsp/window"
+	"github.com/peragwin/vuzicgo/audio/util"
+)
+
+// minBinsPerBucket is how many FFT bins a bucket needs to span before a shorter window is
+// considered fine enough for it.
+const minBinsPerBucket = 2
+
+type resolution struct {
+	size     int
+	window   []float64
+	bucketer *util.Bucketer
+	used     bool
+}
+
+// MultiResolutionProcessor runs FFTs of several sizes over the same signal and stitches
+// them into a single bucketed spectrum. Each bucket uses the shortest window that still
+// resolves it, so low buckets get long windows with fine frequency resolution and high
+// buckets get short windows that respond quickly. The spectrum of each size is bucketed
+// by a util.Bucketer, so the buckets match those of a single FFT.
+type MultiResolutionProcessor struct {
+	SampleRate float64
+
+	resolutions []*resolution
+	// assign holds the index into resolutions used for each bucket
+	assign []int
+
+	history []float64
+}
+
+// NewMultiResolutionProcessor creates a MultiResolutionProcessor that buckets a signal
+// the way @cfg describes using FFTs of the given @sizes. The Size of @cfg is ignored, as
+// there's a Bucketer for each FFT size.
+func NewMultiResolutionProcessor(cfg util.BucketerConfig, sizes []int) (*MultiResolutionProcessor, error) {
+	if len(sizes) == 0 {
+		return nil, errors.New("at least one FFT size is required")
+	}
+
+	sorted := append([]int(nil), sizes...)
+	sort.Ints(sorted)
+	m := &MultiResolutionProcessor{
+		SampleRate: cfg.SampleRate,
+		history:    make([]float64, sorted[len(sorted)-1]),
+	}
+	for _, size := range sorted {
+		if size < 2 || size%2 != 0 {
+			return nil, fmt.Errorf("FFT size %d must be even", size)
+		}
+		cfg.Size = size / 2
+		b, err := util.NewBucketerFromConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		m.resolutions = append(m.resolutions, &resolution{
+			size:     size,
+			window:   window.Hamming(size),
+			bucketer: b,
+		})
+	}
+	edges := m.resolutions[0].bucketer.Edges()
+	m.assign = make([]int, len(edges)-1)
+	for i := range m.assign {
+		width := edges[i+1] - edges[i]
+		// fall back to the longest window if none are fine enough
+		r := len(m.resolutions) - 1
+		for j, res := range m.resolutions {
+			if cfg.SampleRate/float64(res.size)*minBinsPerBucket <= width {
+				r = j
+				break
+			}
+		}
+		m.assign[i] = r
+		m.resolutions[r].used = true
+	}
+
+	return m, nil
+}
+
+// Buckets returns the number of buckets in each output frame.
+func (m *MultiResolutionProcessor) Buckets() int {
+	return len(m.assign)
+}
+
+// Size returns the FFT size used for @bucket.
+func (m *MultiResolutionProcessor) Size(bucket int) int {
+	return m.resolutions[m.assign[bucket]].size
+}
+
+// Latency returns how far the center of the window used for @bucket lags behind the
+// newest sample in each output frame.
+func (m *MultiResolutionProcessor) Latency(bucket int) time.Duration {
+	return m.sizeLatency(m.Size(bucket))
+}
+
+// MaxLatency returns the largest latency of any bucket.
+func (m *MultiResolutionProcessor) MaxLatency() time.Duration {
+	var max time.Duration
+	for i := range m.assign {
+		if l := m.Latency(i); l > max {
+			max = l
+		}
+	}
+	return max
+}
+
+func (m *MultiResolutionProcessor) sizeLatency(size int) time.Duration {
+	return time.Duration(float64(size) / 2 / m.SampleRate * float64(time.Second))
+}
+
+// Process kicks off a goroutine that takes incoming blocks of time domain samples, of any
+// length, and outputs a bucketed spectrum for each of them.
+func (m *MultiResolutionProcessor) Process(done chan struct{}, in chan []float64) chan []float64 {
+	out := make(chan []float64)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			x := <-in
+			if x == nil {
+				return
+			}
+
+			out <- m.Analyze(x)
+		}
+	}()
+
+	return out
+}
+
+// Analyze appends the samples @x to the history and returns the bucketed spectrum.
+func (m *MultiResolutionProcessor) Analyze(x []float64) []float64 {
+	if len(x) >= len(m.history) {
+		copy(m.history, x[len(x)-len(m.history):])
+	} else {
+		copy(m.history, m.history[len(x):])
+		copy(m.history[len(m.history)-len(x):], x)
+	}
+
+	spectra := make([][]float64, len(m.resolutions))
+	for i, res := range m.resolutions {
+		if res.used {
+			// the bucketer was built for this size, so it can't fail
+			spectra[i], _ = res.bucketer.Bucket(m.spectrum(res))
+		}
+	}
+
+	buckets := make([]float64, len(m.assign))
+	for i, r := range m.assign {
+		buckets[i] = spectra[r][i]
+	}
+	return buckets
+}
+
+// Edges returns the Buckets+1 frequencies in Hz that separate the buckets.
+func (m *MultiResolutionProcessor) Edges() []float64 {
+	return m.resolutions[0].bucketer.Edges()
+}
+
+// spectrum returns the magnitude spectrum of the newest res.size samples, scaled the same
+// way as the PowerSpectrumProcessor.
+func (m *MultiResolutionProcessor) spectrum(res *resolution) []float64 {
+	fx := make([]float64, res.size)
+	copy(fx, m.history[len(m.history)-res.size:])
+	for i := range fx {
+		fx[i] *= res.window[i]
+	}
+	Fx := fft.FFTReal(fx)[:res.size/2]
+
+	Px := make([]float64, len(Fx))
+	N := float64(len(Px))
+	for i, f := range Fx {
+		Px[i] = math.Log(1 + cmplx.Abs(f)/N)
+	}
+	return Px
+}
@@ -0,0 +1,80 @@
+package fft
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/peragwin/vuzicgo/audio/util"
+)
+
+func TestMultiResolution(t *testing.T) {
+	sampleRate := 44100.0
+	// eight octave wide buckets from 40Hz to 10240Hz
+	cfg := util.BucketerConfig{
+		Scale:      util.LogScale,
+		Buckets:    8,
+		SampleRate: sampleRate,
+		FMin:       40,
+		FMax:       10240,
+	}
+	m, err := NewMultiResolutionProcessor(cfg, []int{512, 8192, 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSizes := []int{8192, 2048, 2048, 512, 512, 512, 512, 512}
+	for i, want := range wantSizes {
+		if got := m.Size(i); got != want {
+			t.Errorf("bucket %d: expected FFT size %d, got %d", i, want, got)
+		}
+	}
+	if want := time.Duration(4096 / sampleRate * float64(time.Second)); m.MaxLatency() != want {
+		t.Errorf("expected max latency %v, got %v", want, m.MaxLatency())
+	}
+
+	// a 1kHz tone shows up in the fifth bucket, with some leakage into its neighbors from
+	// the short window
+	block := 1024
+	var out []float64
+	for n := 0; n < 16; n++ {
+		x := make([]float64, block)
+		for i := range x {
+			x[i] = math.Sin(2 * math.Pi * 1000 * float64(n*block+i) / sampleRate)
+		}
+		out = m.Analyze(x)
+	}
+	t.Log(out)
+	if len(out) != cfg.Buckets {
+		t.Fatal("expected one value per bucket, got", len(out))
+	}
+	for i, v := range out {
+		if i != 4 && v > out[4]/5 {
+			t.Errorf("expected bucket %d to be quiet, got %v vs %v", i, v, out[4])
+		}
+	}
+
+	// the same buckets as a single FFT of the size a bucket uses
+	b, err := util.NewBucketer(cfg.Scale, cfg.Buckets, 256, sampleRate, cfg.FMin, cfg.FMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := b.Bucket(m.spectrum(m.resolutions[0]))
+	if math.Abs(out[7]-want[7]) > 1e-12 {
+		t.Errorf("expected bucket 7 to match the bucketer, got %v and %v", out[7], want[7])
+	}
+
+	// a bucket that no window resolves gets the longest
+	cfg.Buckets, cfg.FMin, cfg.FMax = 64, 20, 20000
+	if m, err = NewMultiResolutionProcessor(cfg, []int{1024, 4096}); err != nil {
+		t.Fatal(err)
+	}
+	if m.Size(0) != 4096 {
+		t.Errorf("expected the narrowest bucket to get the longest window, got %d", m.Size(0))
+	}
+
+	cfg.FMin = cfg.FMax * 2
+	if _, err := NewMultiResolutionProcessor(cfg, []int{1024}); err == nil {
+		t.Error("expected an invalid config to be rejected")
+	}
+}
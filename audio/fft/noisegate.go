@@ -0,0 +1,285 @@
+package fft
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+)
+
+// NoiseGateParams control how the NoiseGateProcessor suppresses the noise floor.
+type NoiseGateParams struct {
+	// Threshold is how far over the noise floor, as a multiple of it, the smoothed level of
+	// a bin has to be for the gate to open. The floor is then subtracted from the bin. The
+	// floor follows the quietest the noise gets, so noise alone reaches a few times it.
+	Threshold float64 `json:"threshold"`
+	// Release is the fraction of a bin's gain that's kept each frame once it falls below
+	// the threshold, so that gated bins fade out instead of switching off.
+	Release float64 `json:"release"`
+	// Attenuation is the minimum gain applied to a gated bin.
+	Attenuation float64 `json:"attenuation"`
+}
+
+// DefaultNoiseGateParams are a set of parameters that work okay.
+var DefaultNoiseGateParams = NoiseGateParams{
+	Threshold:   5,
+	Release:     0.9,
+	Attenuation: 0,
+}
+
+func (p *NoiseGateParams) validate() error {
+	if p.Threshold < 0 {
+		return errors.New("threshold must not be negative")
+	}
+	if p.Release < 0 || p.Release >= 1 {
+		return errors.New("release must be in [0, 1)")
+	}
+	if p.Attenuation < 0 || p.Attenuation > 1 {
+		return errors.New("attenuation must be in [0, 1]")
+	}
+	return nil
+}
+
+const (
+	// smoothing applied to the spectrum before tracking its minimum
+	noiseSmoothing = 0.85
+	// the minimum is searched over noiseSubwindows windows of noiseSubwindowSize frames
+	noiseSubwindows    = 8
+	noiseSubwindowSize = 12
+	// the minimum of a noisy signal underestimates its mean
+	noiseBias = 1.5
+	// gateClosed is the gain under which a released bin is closed, so that a gain
+	// controller after the gate can't raise what's left of it back up
+	gateClosed = 1e-3
+)
+
+// NoiseGateProcessor estimates the noise floor of each bin of a spectrum using minimum
+// statistics and gates or subtracts it. It sits between the PowerSpectrumProcessor and
+// the Bucketer so that room noise doesn't get amplified when nothing is playing. A tone
+// that's held for longer than the window of the minimum becomes the floor too.
+type NoiseGateProcessor struct {
+	lock   sync.Mutex
+	params NoiseGateParams
+
+	smoothed     []float64
+	initSmoothed bool
+	// subMin is the minimum of the current subwindow and window the minimums of the
+	// last noiseSubwindows subwindows.
+	subMin    []float64
+	window    [noiseSubwindows][]float64
+	subFrames int
+	subIndex  int
+	// filled is set once the window covers noiseSubwindows full subwindows. Until then the
+	// minimum isn't known, so the floor is held at zero.
+	filled bool
+
+	floor []float64
+	gain  []float64
+}
+
+// NewNoiseGateProcessor creates a NoiseGateProcessor with @params.
+func NewNoiseGateProcessor(params NoiseGateParams) (*NoiseGateProcessor, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	return &NoiseGateProcessor{params: params}, nil
+}
+
+// Params returns the current parameters.
+func (n *NoiseGateProcessor) Params() NoiseGateParams {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.params
+}
+
+// SetParams replaces the parameters used for the following frames.
+func (n *NoiseGateProcessor) SetParams(params NoiseGateParams) error {
+	if err := params.validate(); err != nil {
+		return err
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.params = params
+	return nil
+}
+
+// Floor returns a copy of the current noise floor estimate of each bin.
+func (n *NoiseGateProcessor) Floor() []float64 {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	floor := make([]float64, len(n.floor))
+	copy(floor, n.floor)
+	return floor
+}
+
+// Process kicks off a goroutine to gate incoming @in spectra and returns the output
+// channel.
+func (n *NoiseGateProcessor) Process(done chan struct{}, in chan []float64) chan []float64 {
+	out := make(chan []float64)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			Px := <-in
+			if Px == nil {
+				return
+			}
+
+			out <- n.Gate(Px)
+		}
+	}()
+
+	return out
+}
+
+// Gate updates the noise floor with the spectrum @Px and returns a gated copy of it.
+func (n *NoiseGateProcessor) Gate(Px []float64) []float64 {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if len(Px) != len(n.floor) {
+		n.reset(len(Px))
+	}
+	n.trackFloor(Px)
+
+	p := n.params
+	y := make([]float64, len(Px))
+	for i, x := range Px {
+		// spectral subtraction in the bins that are open, expressed as a gain so that it
+		// can be released smoothly
+		g := p.Release * n.gain[i]
+		if n.smoothed[i] > p.Threshold*n.floor[i] && x > 0 {
+			g = math.Max(g, 1-n.floor[i]/x)
+		}
+		if g < gateClosed {
+			g = 0
+		}
+		g = math.Max(g, p.Attenuation)
+		g = math.Min(g, 1)
+		n.gain[i] = g
+		y[i] = g * x
+	}
+	return y
+}
+
+func (n *NoiseGateProcessor) reset(size int) {
+	n.smoothed = make([]float64, size)
+	n.subMin = make([]float64, size)
+	for i := range n.window {
+		n.window[i] = make([]float64, size)
+		for j := range n.window[i] {
+			n.window[i][j] = math.Inf(1)
+		}
+	}
+	for i := range n.subMin {
+		n.subMin[i] = math.Inf(1)
+	}
+	n.subFrames = 0
+	n.subIndex = 0
+	n.filled = false
+	n.floor = make([]float64, size)
+	n.gain = make([]float64, size)
+	// nothing is known about the noise yet, so start from the first frame
+	n.initSmoothed = true
+}
+
+func (n *NoiseGateProcessor) trackFloor(Px []float64) {
+	for i, x := range Px {
+		if n.initSmoothed {
+			n.smoothed[i] = x
+		} else {
+			n.smoothed[i] = noiseSmoothing*n.smoothed[i] + (1-noiseSmoothing)*x
+		}
+		n.subMin[i] = math.Min(n.subMin[i], n.smoothed[i])
+	}
+	n.initSmoothed = false
+
+	n.subFrames++
+	if n.subFrames == noiseSubwindowSize {
+		copy(n.window[n.subIndex], n.subMin)
+		for i := range n.subMin {
+			n.subMin[i] = math.Inf(1)
+		}
+		n.subIndex = (n.subIndex + 1) % noiseSubwindows
+		n.subFrames = 0
+		n.filled = n.filled || n.subIndex == 0
+	}
+
+	if !n.filled {
+		return
+	}
+	for i := range n.floor {
+		min := n.subMin[i]
+		for _, w := range n.window {
+			min = math.Min(min, w[i])
+		}
+		n.floor[i] = noiseBias * min
+	}
+}
+
+var noiseGateType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "NoiseGateType",
+		Fields: graphql.Fields{
+			"threshold":   &graphql.Field{Type: graphql.Float},
+			"release":     &graphql.Field{Type: graphql.Float},
+			"attenuation": &graphql.Field{Type: graphql.Float},
+		},
+	},
+)
+
+// QueryFields publishes the noise floor estimate as "noiseFloor" and the gate parameters
+// as "noiseGate".
+func (n *NoiseGateProcessor) QueryFields() graphql.Fields {
+	return graphql.Fields{
+		"noiseFloor": &graphql.Field{
+			Type: graphql.NewList(graphql.Float),
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return n.Floor(), nil
+			},
+		},
+		"noiseGate": &graphql.Field{
+			Type: noiseGateType,
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return n.Params(), nil
+			},
+		},
+	}
+}
+
+// MutationFields adds "noiseGate" to change the gate parameters.
+func (n *NoiseGateProcessor) MutationFields() graphql.Fields {
+	return graphql.Fields{
+		"noiseGate": &graphql.Field{
+			Type: noiseGateType,
+			Args: graphql.FieldConfigArgument{
+				"threshold":   &graphql.ArgumentConfig{Type: graphql.Float},
+				"release":     &graphql.ArgumentConfig{Type: graphql.Float},
+				"attenuation": &graphql.ArgumentConfig{Type: graphql.Float},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				params := n.Params()
+				if v, ok := p.Args["threshold"]; ok {
+					params.Threshold = v.(float64)
+				}
+				if v, ok := p.Args["release"]; ok {
+					params.Release = v.(float64)
+				}
+				if v, ok := p.Args["attenuation"]; ok {
+					params.Attenuation = v.(float64)
+				}
+				if err := n.SetParams(params); err != nil {
+					return nil, err
+				}
+				return params, nil
+			},
+		},
+	}
+}
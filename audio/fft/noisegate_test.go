@@ -0,0 +1,82 @@
+package fft
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestNoiseGate(t *testing.T) {
+	n, err := NewNoiseGateProcessor(DefaultNoiseGateParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := 64
+	noise := func() []float64 {
+		x := make([]float64, size)
+		for i := range x {
+			x[i] = 0.01 + 0.01*rand.Float64()
+		}
+		return x
+	}
+
+	// the gate is open until the noise floor is known
+	x := noise()
+	y := n.Gate(x)
+	for i, v := range y {
+		if v != x[i] {
+			t.Fatalf("expected bin %d to pass before the floor is known, got %v of %v", i, v, x[i])
+		}
+	}
+
+	// room noise alone is cut
+	for i := 0; i < 200; i++ {
+		y = n.Gate(noise())
+	}
+	for i, v := range y {
+		if v != 0 {
+			t.Fatalf("expected bin %d to be gated, got %v", i, v)
+		}
+	}
+	floor := n.Floor()
+	for i, v := range floor {
+		if v < 0.005 || v > 0.03 {
+			t.Errorf("noise floor of bin %d out of range: %v", i, v)
+		}
+	}
+
+	// a tone well above the floor passes
+	x = noise()
+	x[10] = 1
+	y = n.Gate(x)
+	if y[10] < 0.9 {
+		t.Error("expected tone to pass the gate, got", y[10])
+	}
+
+	// and fades out with the release once it stops
+	y = n.Gate(noise())
+	if y[10] == 0 {
+		t.Error("expected tone to be released gradually")
+	}
+
+	// a drone passes until it's held long enough to become the floor
+	drone, err := NewNoiseGateProcessor(DefaultNoiseGateParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < noiseSubwindows*noiseSubwindowSize-1; i++ {
+		if y = drone.Gate([]float64{0.5}); y[0] != 0.5 {
+			t.Fatalf("expected the drone to pass on frame %d, got %v", i, y[0])
+		}
+	}
+	for i := 0; i < 100; i++ {
+		y = drone.Gate([]float64{0.5})
+	}
+	if y[0] != 0 {
+		t.Error("expected a sustained drone to be gated, got", y[0])
+	}
+
+	if err := n.SetParams(NoiseGateParams{Release: 1}); err == nil {
+		t.Error("expected release of 1 to be invalid")
+	}
+}
@@ -0,0 +1,225 @@
+package chromasensor
+
+import (
+	"math"
+)
+
+// NumPitchClasses is the number of pitch classes in an octave.
+const NumPitchClasses = 12
+
+var pitchNames = [NumPitchClasses]string{
+	"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B",
+}
+
+// PitchName returns the name of the pitch class @pc, where 0 is C.
+func PitchName(pc int) string {
+	return pitchNames[mod12(pc)]
+}
+
+// Config is passed to initialize a ChromaSensor.
+type Config struct {
+	// Frequencies is the center frequency in Hz of each value in an incoming frame. Use
+	// LinearFrequencies for the output of fft.PowerSpectrumProcessor, or pass the bin
+	// frequencies of a constant-Q transform.
+	Frequencies []float64
+	// FMin and FMax bound the part of the spectrum that is folded into pitch classes.
+	FMin float64
+	FMax float64
+	// TuningDecay is how much of the previous tuning estimate is kept each frame.
+	TuningDecay float64
+	// KeyDecay is how much of the previous chroma is kept in the running key estimate each
+	// frame. Values close to 1 make the key estimate slow to change.
+	KeyDecay float64
+}
+
+// DefaultConfig returns a config for a linear spectrum of @frameSize bins as produced by
+// fft.PowerSpectrumProcessor at @sampleRate.
+func DefaultConfig(sampleRate float64, frameSize int) *Config {
+	return &Config{
+		Frequencies: LinearFrequencies(sampleRate, frameSize),
+		FMin:        55,
+		FMax:        5000,
+		TuningDecay: 0.99,
+		KeyDecay:    0.995,
+	}
+}
+
+// LinearFrequencies returns the center frequency of each bin of a spectrum of @frameSize
+// bins, which is half of the FFT size.
+func LinearFrequencies(sampleRate float64, frameSize int) []float64 {
+	f := make([]float64, frameSize)
+	df := sampleRate / float64(2*frameSize)
+	for i := range f {
+		f[i] = float64(i) * df
+	}
+	return f
+}
+
+// Chroma is the output of a ChromaSensor for a single frame.
+type Chroma struct {
+	// Pitch is the energy in each pitch class starting at C, normalized so that the
+	// largest is 1.
+	Pitch [NumPitchClasses]float64
+	// Tuning is the estimated offset of the reference pitch from A440 in semitones,
+	// in the range [-0.5, 0.5).
+	Tuning float64
+	// Key is the running estimate of the musical key.
+	Key Key
+	// KeyStrength is the correlation of the running chroma with the profile of Key.
+	KeyStrength float64
+	// Chord is the triad that best matches this frame's chroma.
+	Chord Key
+	// ChordStrength is the correlation of this frame's chroma with the Chord template.
+	ChordStrength float64
+}
+
+// ChromaSensor folds a spectrum into 12 pitch classes and estimates tuning, key and chord.
+type ChromaSensor struct {
+	cfg *Config
+
+	// tuning is accumulated as a phasor so that deviations wrap around the semitone.
+	tuningRe float64
+	tuningIm float64
+
+	keyChroma [NumPitchClasses]float64
+}
+
+// NewChromaSensor creates a new ChromaSensor from a Config.
+func NewChromaSensor(cfg *Config) *ChromaSensor {
+	return &ChromaSensor{cfg: cfg}
+}
+
+// Process kicks off a goroutine to process incoming spectrum frames and returns the
+// output channel.
+func (c *ChromaSensor) Process(done chan struct{}, in chan []float64) chan *Chroma {
+	out := make(chan *Chroma)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			x := <-in
+			if x == nil {
+				return
+			}
+			out <- c.process(x)
+		}
+	}()
+
+	return out
+}
+
+type peak struct {
+	freq float64
+	mag  float64
+}
+
+func (c *ChromaSensor) process(frame []float64) *Chroma {
+	peaks := c.findPeaks(frame)
+	c.updateTuning(peaks)
+
+	ch := &Chroma{Tuning: c.tuning()}
+	for _, p := range peaks {
+		pc := mod12(int(math.Floor(midiPitch(p.freq) - ch.Tuning + 0.5)))
+		ch.Pitch[pc] += p.mag
+	}
+	normalize(ch.Pitch[:])
+
+	for i := range c.keyChroma {
+		c.keyChroma[i] = c.cfg.KeyDecay*c.keyChroma[i] + (1-c.cfg.KeyDecay)*ch.Pitch[i]
+	}
+	ch.Key, ch.KeyStrength = estimateKey(c.keyChroma)
+	ch.Chord, ch.ChordStrength = estimateChord(ch.Pitch)
+
+	return ch
+}
+
+// findPeaks returns the local maxima of the frame within [FMin, FMax], with the frequency
+// of each refined by fitting a parabola through it and its neighbors.
+func (c *ChromaSensor) findPeaks(frame []float64) []peak {
+	freqs := c.cfg.Frequencies
+	n := len(frame)
+	if len(freqs) < n {
+		n = len(freqs)
+	}
+
+	var peaks []peak
+	for i := 1; i < n-1; i++ {
+		f := freqs[i]
+		if f < c.cfg.FMin || f > c.cfg.FMax {
+			continue
+		}
+		a, b, g := frame[i-1], frame[i], frame[i+1]
+		if b <= 0 || b <= a || b < g {
+			continue
+		}
+		// the offset of the vertex of the parabola, in bins
+		var delta float64
+		if den := a - 2*b + g; den != 0 {
+			delta = 0.5 * (a - g) / den
+		}
+		if delta >= 0 {
+			f += delta * (freqs[i+1] - freqs[i])
+		} else {
+			f += delta * (freqs[i] - freqs[i-1])
+		}
+		peaks = append(peaks, peak{freq: f, mag: b - 0.25*(a-g)*delta})
+	}
+	return peaks
+}
+
+func (c *ChromaSensor) updateTuning(peaks []peak) {
+	var re, im float64
+	for _, p := range peaks {
+		dev := midiPitch(p.freq)
+		ph := 2 * math.Pi * (dev - math.Floor(dev))
+		re += p.mag * math.Cos(ph)
+		im += p.mag * math.Sin(ph)
+	}
+	d := c.cfg.TuningDecay
+	c.tuningRe = d*c.tuningRe + (1-d)*re
+	c.tuningIm = d*c.tuningIm + (1-d)*im
+}
+
+func (c *ChromaSensor) tuning() float64 {
+	if c.tuningRe == 0 && c.tuningIm == 0 {
+		return 0
+	}
+	t := math.Atan2(c.tuningIm, c.tuningRe) / (2 * math.Pi)
+	if t >= 0.5 {
+		t -= 1
+	}
+	return t
+}
+
+// midiPitch returns the fractional MIDI note number of a frequency, where A440 is 69.
+func midiPitch(f float64) float64 {
+	return 69 + 12*math.Log2(f/440)
+}
+
+func normalize(x []float64) {
+	max := 0.0
+	for _, v := range x {
+		if v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		return
+	}
+	for i := range x {
+		x[i] /= max
+	}
+}
+
+func mod12(i int) int {
+	i %= NumPitchClasses
+	if i < 0 {
+		i += NumPitchClasses
+	}
+	return i
+}
@@ -0,0 +1,102 @@
+package chromasensor
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/mjibson/go-dsp/fft"
+	"github.com/mjibson/go-dsp/window"
+)
+
+const (
+	testSampleRate = 44100
+	testFFTSize    = 8192
+)
+
+// spectrum returns the magnitude spectrum of a sum of sines at @freqs.
+func spectrum(freqs ...float64) []float64 {
+	x := make([]float64, testFFTSize)
+	for _, f := range freqs {
+		for i := range x {
+			x[i] += math.Sin(2 * math.Pi * f * float64(i) / testSampleRate)
+		}
+	}
+	window.Apply(x, window.Hann)
+	Fx := fft.FFTReal(x)[:testFFTSize/2]
+	Px := make([]float64, len(Fx))
+	for i, f := range Fx {
+		Px[i] = cmplx.Abs(f) / float64(len(Px))
+	}
+	return Px
+}
+
+func noteFreq(midi, tuning float64) float64 {
+	return 440 * math.Exp2((midi+tuning-69)/12)
+}
+
+func TestChromaTriad(t *testing.T) {
+	c := NewChromaSensor(DefaultConfig(testSampleRate, testFFTSize/2))
+
+	// C4 E4 G4 tuned a quarter tone sharp
+	tuning := 0.25
+	frame := spectrum(noteFreq(60, tuning), noteFreq(64, tuning), noteFreq(67, tuning))
+
+	var ch *Chroma
+	for i := 0; i < 500; i++ {
+		ch = c.process(frame)
+	}
+	t.Log(ch.Pitch, ch.Tuning, ch.Key, ch.Chord)
+
+	if math.Abs(ch.Tuning-tuning) > 0.05 {
+		t.Errorf("expected tuning %v, got %v", tuning, ch.Tuning)
+	}
+	for _, pc := range []int{0, 4, 7} {
+		if ch.Pitch[pc] < 0.5 {
+			t.Errorf("expected energy in %s: %v", PitchName(pc), ch.Pitch)
+		}
+	}
+	if want := (Key{Tonic: 0}); ch.Chord != want {
+		t.Errorf("expected chord %v, got %v", want, ch.Chord)
+	}
+}
+
+func TestKeyEstimate(t *testing.T) {
+	c := NewChromaSensor(DefaultConfig(testSampleRate, testFFTSize/2))
+
+	// a i-iv-V-i progression in A minor
+	chords := [][]float64{
+		{57, 60, 64}, // Am
+		{62, 65, 69}, // Dm
+		{64, 68, 71}, // E
+		{57, 60, 64}, // Am
+	}
+	frames := make([][]float64, len(chords))
+	for i, ch := range chords {
+		freqs := make([]float64, len(ch))
+		for j, n := range ch {
+			freqs[j] = noteFreq(n, 0)
+		}
+		frames[i] = spectrum(freqs...)
+	}
+
+	var ch *Chroma
+	for i := 0; i < 2000; i++ {
+		ch = c.process(frames[(i/50)%len(frames)])
+	}
+	if want := (Key{Tonic: 9, Minor: true}); ch.Key != want {
+		t.Errorf("expected key %v, got %v (%v)", want, ch.Key, ch.KeyStrength)
+	}
+}
+
+func TestKeyHue(t *testing.T) {
+	if h := (Key{Tonic: 0}).Hue(); h != 0 {
+		t.Error("C major hue", h)
+	}
+	if h := (Key{Tonic: 9, Minor: true}).Hue(); h != 0 {
+		t.Error("A minor should share a hue with C major", h)
+	}
+	if h := (Key{Tonic: 7}).Hue(); h != 30 {
+		t.Error("G major should be one step around the circle of fifths", h)
+	}
+}
@@ -0,0 +1,92 @@
+package chromasensor
+
+import (
+	"math"
+)
+
+// Key is a tonic pitch class with a major or minor mode. It's used for both key and chord
+// estimates.
+type Key struct {
+	Tonic int
+	Minor bool
+}
+
+func (k Key) String() string {
+	if k.Minor {
+		return PitchName(k.Tonic) + " minor"
+	}
+	return PitchName(k.Tonic) + " major"
+}
+
+// Hue maps the key onto the circle of fifths and returns a hue in degrees. Relative
+// major and minor keys share a hue, so palettes chosen by it follow the harmony rather
+// than the exact mode.
+func (k Key) Hue() float64 {
+	tonic := k.Tonic
+	if k.Minor {
+		tonic += 3
+	}
+	return float64(mod12(7*tonic)) * 360 / NumPitchClasses
+}
+
+// Krumhansl-Kessler key profiles starting at the tonic.
+var (
+	majorProfile = [NumPitchClasses]float64{
+		6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88,
+	}
+	minorProfile = [NumPitchClasses]float64{
+		6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17,
+	}
+
+	majorTriad = [NumPitchClasses]float64{1, 0, 0, 0, 1, 0, 0, 1, 0, 0, 0, 0}
+	minorTriad = [NumPitchClasses]float64{1, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0}
+)
+
+func estimateKey(chroma [NumPitchClasses]float64) (Key, float64) {
+	return bestMatch(chroma, &majorProfile, &minorProfile)
+}
+
+func estimateChord(chroma [NumPitchClasses]float64) (Key, float64) {
+	return bestMatch(chroma, &majorTriad, &minorTriad)
+}
+
+// bestMatch correlates the chroma with all 12 rotations of a major and minor template and
+// returns the best match along with its correlation.
+func bestMatch(chroma [NumPitchClasses]float64, major, minor *[NumPitchClasses]float64) (Key, float64) {
+	var best Key
+	bestR := math.Inf(-1)
+	for tonic := 0; tonic < NumPitchClasses; tonic++ {
+		if r := correlate(chroma, major, tonic); r > bestR {
+			best, bestR = Key{Tonic: tonic}, r
+		}
+		if r := correlate(chroma, minor, tonic); r > bestR {
+			best, bestR = Key{Tonic: tonic, Minor: true}, r
+		}
+	}
+	return best, bestR
+}
+
+// correlate returns the Pearson correlation of the chroma with the template rotated so
+// that its first entry lines up with @tonic.
+func correlate(chroma [NumPitchClasses]float64, template *[NumPitchClasses]float64, tonic int) float64 {
+	var mx, my float64
+	for i := range chroma {
+		mx += chroma[i]
+		my += template[i]
+	}
+	mx /= NumPitchClasses
+	my /= NumPitchClasses
+
+	var sxy, sxx, syy float64
+	for i := range chroma {
+		dx := chroma[mod12(i+tonic)] - mx
+		dy := template[i] - my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return 0
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
@@ -0,0 +1,376 @@
+package freqsensor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/peragwin/vuzicgo/audio/util"
+)
+
+// Band weightings
+const (
+	FlatWeighting = "flat"
+	LowWeighting  = "low"
+	HighWeighting = "high"
+	PeakWeighting = "peak"
+)
+
+// Band sources
+const (
+	DiffSource = "diff"
+	AmpSource  = "amp"
+)
+
+// Band output curves
+const (
+	LinearBandCurve = "linear"
+	LogBandCurve    = "log"
+	SqrtBandCurve   = "sqrt"
+	SquareBandCurve = "square"
+)
+
+// Band is a driver that follows the level of a range of frequencies.
+type Band struct {
+	Name string `json:"name" yaml:"name"`
+	// FMin and FMax are the range of the band in Hz.
+	FMin float64 `json:"fMin" yaml:"fMin"`
+	FMax float64 `json:"fMax" yaml:"fMax"`
+	// Source is the channel the band follows, "diff" or "amp". It defaults to "diff".
+	Source string `json:"source" yaml:"source"`
+	// Weighting is how the buckets in the range are combined: "flat" averages them, "low"
+	// and "high" favor one end of the range, and "peak" takes the largest. It defaults to
+	// "flat".
+	Weighting string `json:"weighting" yaml:"weighting"`
+	// Attack and Release are the time constants of the envelope in ms, for when the level
+	// is rising and falling.
+	Attack  float64 `json:"attack" yaml:"attack"`
+	Release float64 `json:"release" yaml:"release"`
+	// Curve shapes the output of the envelope: "linear", "log", "sqrt" or "square". It
+	// defaults to "linear".
+	Curve string `json:"curve" yaml:"curve"`
+	// Gain scales the output. It defaults to 1.
+	Gain float64 `json:"gain" yaml:"gain"`
+}
+
+// DefaultBands are the bands that a sensor drives unless its Config gives others.
+var DefaultBands = []Band{
+	{Name: "sub", FMin: 20, FMax: 60, Weighting: LowWeighting, Attack: 10, Release: 150, Curve: LogBandCurve},
+	{Name: "bass", FMin: 60, FMax: 250, Weighting: LowWeighting, Attack: 10, Release: 120, Curve: LogBandCurve},
+	{Name: "low-mid", FMin: 250, FMax: 500, Attack: 10, Release: 100, Curve: LogBandCurve},
+	{Name: "mid", FMin: 500, FMax: 2000, Attack: 5, Release: 80, Curve: LogBandCurve},
+	{Name: "presence", FMin: 2000, FMax: 6000, Attack: 5, Release: 60, Curve: LogBandCurve},
+	{Name: "air", FMin: 6000, FMax: 20000, Weighting: HighWeighting, Attack: 5, Release: 60, Curve: LogBandCurve},
+}
+
+// Validate checks the band and fills in defaults.
+func (b *Band) Validate() error {
+	if b.Name == "" {
+		return errors.New("band has no name")
+	}
+	if !(b.FMin >= 0 && b.FMax > b.FMin) {
+		return fmt.Errorf("band %s: range [%v, %v] is empty", b.Name, b.FMin, b.FMax)
+	}
+	if b.Attack < 0 || b.Release < 0 || math.IsNaN(b.Attack) || math.IsNaN(b.Release) {
+		return fmt.Errorf("band %s: attack and release must not be negative", b.Name)
+	}
+	if b.Gain == 0 {
+		b.Gain = 1
+	}
+	if math.IsNaN(b.Gain) || math.IsInf(b.Gain, 0) {
+		return fmt.Errorf("band %s: gain must be a number", b.Name)
+	}
+	for _, f := range []struct {
+		val     *string
+		def     string
+		allowed []string
+	}{
+		{&b.Source, DiffSource, []string{DiffSource, AmpSource}},
+		{&b.Weighting, FlatWeighting, []string{FlatWeighting, LowWeighting, HighWeighting, PeakWeighting}},
+		{&b.Curve, LinearBandCurve, []string{LinearBandCurve, LogBandCurve, SqrtBandCurve, SquareBandCurve}},
+	} {
+		if *f.val == "" {
+			*f.val = f.def
+		}
+		ok := false
+		for _, a := range f.allowed {
+			ok = ok || *f.val == a
+		}
+		if !ok {
+			return fmt.Errorf("band %s: unknown value %q", b.Name, *f.val)
+		}
+	}
+	return nil
+}
+
+func (b *Band) shape(x float64) float64 {
+	x = math.Max(x, 0)
+	switch b.Curve {
+	case LogBandCurve:
+		x = math.Log(1 + x)
+	case SqrtBandCurve:
+		x = math.Sqrt(x)
+	case SquareBandCurve:
+		x = x * x
+	}
+	return b.Gain * x
+}
+
+// bandDriver is a band with its weights over the buckets and the state of its envelope.
+type bandDriver struct {
+	Band
+	weights []float64
+	level   float64
+	value   float64
+}
+
+// weigh works out how much each bucket between @edges counts toward the band.
+func (bd *bandDriver) weigh(edges []float64) {
+	bd.weights = make([]float64, len(edges)-1)
+	var total float64
+	for i := range bd.weights {
+		lo, hi := edges[i], edges[i+1]
+		overlap := math.Min(hi, bd.FMax) - math.Max(lo, bd.FMin)
+		if overlap <= 0 || hi <= lo {
+			continue
+		}
+		w := overlap / (hi - lo)
+		// where the bucket's center falls in the band, from 0 at FMin to 1 at FMax
+		x := ((lo+hi)/2 - bd.FMin) / (bd.FMax - bd.FMin)
+		x = math.Max(0, math.Min(1, x))
+		switch bd.Weighting {
+		case LowWeighting:
+			w *= 1 - 0.75*x
+		case HighWeighting:
+			w *= 0.25 + 0.75*x
+		}
+		bd.weights[i] = w
+		total += w
+	}
+	if total > 0 && bd.Weighting != PeakWeighting {
+		for i := range bd.weights {
+			bd.weights[i] /= total
+		}
+	}
+}
+
+func (bd *bandDriver) step(input []float64, t *tuning) {
+	var x float64
+	for i, w := range bd.weights {
+		if w == 0 || i >= len(input) {
+			continue
+		}
+		v := math.Max(input[i], 0) * w
+		if bd.Weighting == PeakWeighting {
+			x = math.Max(x, v)
+		} else {
+			x += v
+		}
+	}
+
+	tao := bd.Release
+	if x > bd.level {
+		tao = bd.Attack
+	}
+	a := 1 / math.Max(t.frames(tao), 1)
+	bd.level += a * (x - bd.level)
+	bd.value = bd.shape(bd.level)
+}
+
+// Bands drives a list of bands from the output of a sensor. It's safe to change the
+// bands while the sensor runs.
+type Bands struct {
+	lock    sync.Mutex
+	drivers []*bandDriver
+	edges   []float64
+}
+
+// NewBands creates the drivers for @bands.
+func NewBands(bands []Band) (*Bands, error) {
+	b := new(Bands)
+	for _, band := range bands {
+		if err := b.Set(band); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Set adds @band, or replaces the band with the same name.
+func (b *Bands) Set(band Band) error {
+	if err := band.Validate(); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	bd := &bandDriver{Band: band}
+	if b.edges != nil {
+		bd.weigh(b.edges)
+	}
+	for i, old := range b.drivers {
+		if old.Name == band.Name {
+			bd.level, bd.value = old.level, old.value
+			b.drivers[i] = bd
+			return nil
+		}
+	}
+	b.drivers = append(b.drivers, bd)
+	return nil
+}
+
+// Remove removes the band called @name and tells whether it existed.
+func (b *Bands) Remove(name string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, bd := range b.drivers {
+		if bd.Name == name {
+			b.drivers = append(b.drivers[:i], b.drivers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// BandState is a band with its current value.
+type BandState struct {
+	Band
+	Value float64 `json:"value"`
+}
+
+// States returns the bands in order with their values.
+func (b *Bands) States() []BandState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	states := make([]BandState, len(b.drivers))
+	for i, bd := range b.drivers {
+		states[i] = BandState{bd.Band, bd.value}
+	}
+	return states
+}
+
+// Value returns the value of the band called @name, and whether it exists.
+func (b *Bands) Value(name string) (float64, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, bd := range b.drivers {
+		if bd.Name == name {
+			return bd.value, true
+		}
+	}
+	return 0, false
+}
+
+// apply steps the bands with the output of a frame and writes their values to @values,
+// which is cleared first. @edges are the frequencies that separate the buckets.
+func (b *Bands) apply(drv *Drivers, edges []float64, t *tuning, values map[string]float64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !floatsEqual(edges, b.edges) {
+		b.edges = edges
+		for _, bd := range b.drivers {
+			bd.weigh(edges)
+		}
+	}
+	for k := range values {
+		delete(values, k)
+	}
+	for _, bd := range b.drivers {
+		input := drv.Diff
+		if bd.Source == AmpSource {
+			input = drv.Amplitude[0]
+		}
+		bd.step(input, t)
+		values[bd.Name] = bd.value
+	}
+}
+
+func floatsEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// edges returns the frequencies that separate the buckets.
+func (d *FrequencySensor) edges() []float64 {
+	if proc := d.bucketProcessor(); proc != nil {
+		if b := proc.Bucketer(); b.Buckets == d.Buckets {
+			return b.Edges()
+		}
+	}
+	cfg := d.bucketCfg
+	return util.ScaleEdges(cfg.Scale, d.Buckets, cfg.FMin, cfg.FMax)
+}
+
+// BandFeaturePrefix starts the names of the audio features of the band drivers, so that
+// "band:bass" is the bass band and "bass" stays the bass driver.
+const BandFeaturePrefix = "band:"
+
+// applyBands updates the band drivers and publishes them, along with the bass, as features
+// for the modulators.
+func (d *FrequencySensor) applyBands() {
+	d.bands.apply(&d.Drivers, d.edges(), d.lastTuning, d.Drivers.Bands)
+	d.modulators.SetFeature("bass", d.Bass)
+	for name, v := range d.Drivers.Bands {
+		d.modulators.SetFeature(BandFeaturePrefix+name, v)
+	}
+}
+
+// Bands returns the band drivers.
+func (d *FrequencySensor) Bands() *Bands {
+	return d.bands
+}
+
+// bandFields returns the graphql fields of BandState and the input fields of Band.
+func bandFields() (graphql.Fields, graphql.InputObjectConfigFieldMap) {
+	fields := graphql.Fields{}
+	inputFields := graphql.InputObjectConfigFieldMap{}
+	ref := reflect.TypeOf(Band{})
+	for i := 0; i < ref.NumField(); i++ {
+		f := ref.Field(i)
+		var typ graphql.Output
+		var in graphql.Input
+		switch f.Type.Kind() {
+		case reflect.String:
+			typ, in = graphql.String, graphql.String
+		case reflect.Float64:
+			typ, in = graphql.Float, graphql.Float
+		}
+		field := i
+		fields[jsonTag(&f)] = &graphql.Field{
+			Type: typ,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				s := p.Source.(BandState)
+				return reflect.ValueOf(s.Band).Field(field).Interface(), nil
+			},
+		}
+		inputFields[jsonTag(&f)] = &graphql.InputObjectFieldConfig{Type: in}
+	}
+	fields["value"] = &graphql.Field{
+		Type: graphql.Float,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(BandState).Value, nil
+		},
+	}
+	return fields, inputFields
+}
+
+// bandFromArgs decodes a Band from the arguments of a mutation.
+func bandFromArgs(args map[string]interface{}) (Band, error) {
+	var b Band
+	bs, err := json.Marshal(args)
+	if err != nil {
+		return b, err
+	}
+	err = json.Unmarshal(bs, &b)
+	return b, err
+}
@@ -0,0 +1,90 @@
+package freqsensor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBandWeights(t *testing.T) {
+	edges := []float64{0, 100, 200, 300, 400}
+	for _, c := range []struct {
+		weighting string
+		want      []float64
+	}{
+		{FlatWeighting, []float64{0, 0.5, 0.5, 0}},
+		{PeakWeighting, []float64{0, 1, 1, 0}},
+	} {
+		bd := &bandDriver{Band: Band{Name: "x", FMin: 100, FMax: 300, Weighting: c.weighting}}
+		bd.weigh(edges)
+		for i, w := range c.want {
+			if math.Abs(bd.weights[i]-w) > 1e-9 {
+				t.Errorf("%s: expected weights %v, got %v", c.weighting, c.want, bd.weights)
+				break
+			}
+		}
+	}
+
+	bd := &bandDriver{Band: Band{Name: "x", FMin: 100, FMax: 300, Weighting: LowWeighting}}
+	bd.weigh(edges)
+	if bd.weights[1] <= bd.weights[2] {
+		t.Errorf("expected the low end to count more, got %v", bd.weights)
+	}
+}
+
+func TestBands(t *testing.T) {
+	for _, b := range []Band{
+		{FMin: 1, FMax: 2},
+		{Name: "x", FMin: 2, FMax: 1},
+		{Name: "x", FMin: 1, FMax: 2, Curve: "cubic"},
+		{Name: "x", FMin: 1, FMax: 2, Attack: -1},
+	} {
+		if _, err := NewBands([]Band{b}); err == nil {
+			t.Errorf("expected an error for %+v", b)
+		}
+	}
+
+	d := newTestSensor(t, nil)
+	if len(d.Bands().States()) != len(DefaultBands) {
+		t.Fatal("expected the default bands")
+	}
+	res := d.Query(`mutation { setBand(band: {name: "all", fMin: 0, fMax: 20000, source: "amp", attack: 0, release: 0}) { name } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+
+	drv := runFrames(d, 10, 1)
+	all, ok := drv.Bands["all"]
+	if !ok || all <= 0 {
+		t.Fatalf("expected a value for the new band, got %v", drv.Bands)
+	}
+	// without an envelope or curve, the band is the average amplitude
+	var avg float64
+	for _, v := range drv.Amplitude[0] {
+		avg += math.Max(v, 0)
+	}
+	avg /= float64(len(drv.Amplitude[0]))
+	if math.Abs(all-avg) > 1e-9 {
+		t.Errorf("expected %v, got %v", avg, all)
+	}
+	if v, _ := d.Bands().Value("all"); v != all {
+		t.Errorf("expected the band's value %v, got %v", all, v)
+	}
+	d.modulators.lock.Lock()
+	bass, band := d.modulators.features["bass"], d.modulators.features["band:all"]
+	d.modulators.lock.Unlock()
+	if bass != drv.Bass || band != all {
+		t.Errorf("expected the bass %v and the band %v as features, got %v and %v",
+			drv.Bass, all, bass, band)
+	}
+
+	res = d.Query(`{ bands { name value } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	if n := len(res.Data.(map[string]interface{})["bands"].([]interface{})); n != len(DefaultBands)+1 {
+		t.Errorf("expected %d bands, got %d", len(DefaultBands)+1, n)
+	}
+	if !d.Bands().Remove("all") || d.Bands().Remove("all") {
+		t.Error("expected the band to be removed once")
+	}
+}
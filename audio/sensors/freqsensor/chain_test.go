@@ -0,0 +1,82 @@
+package freqsensor
+
+import (
+	"math"
+	"testing"
+)
+
+func runFrames(d *FrequencySensor, frames int, level float64) *Drivers {
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64)
+	defer close(in)
+	out := d.ProcessBuckets(done, in)
+	var drv *Drivers
+	for i := 0; i < frames; i++ {
+		frame := make([]float64, d.Buckets)
+		for j := range frame {
+			frame[j] = level
+		}
+		in <- frame
+		drv = <-out
+	}
+	return drv
+}
+
+func TestFilterChain(t *testing.T) {
+	d := newTestSensor(t, nil)
+
+	for _, raw := range [][]float64{nil, {1}, {0.5, 0.5, 1}} {
+		if err := d.SetRawFilter("amp", raw); err == nil {
+			t.Errorf("expected an error for %d coefficients", len(raw))
+		}
+	}
+
+	// a single level that passes its input through
+	if err := d.SetRawFilter("amp", []float64{1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	drv := runFrames(d, 4, 0.5)
+	if levels(d.filterValues.gain) != 1 {
+		t.Fatalf("expected the filter state to have 1 level, got %d", levels(d.filterValues.gain))
+	}
+	for _, v := range drv.Amplitude[0] {
+		if math.IsNaN(v) {
+			t.Fatal("unexpected NaN amplitude")
+		}
+	}
+
+	// three levels of lowpass
+	raw := []float64{
+		0.5, 0.5,
+		0.2, 0.8,
+		-0.01, 0.99,
+	}
+	if err := d.SetRawFilter("amp", raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetFilterParams("amp", 2, 1, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetFilterParams("amp", 3, 1, 4); err == nil {
+		t.Error("expected an error for a level past the end of the chain")
+	}
+	runFrames(d, 4, 0.5)
+	if levels(d.filterValues.gain) != 3 {
+		t.Fatalf("expected the filter state to have 3 levels, got %d", levels(d.filterValues.gain))
+	}
+
+	res := d.Query(`{ filter { amp ampLevels diffLevels } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	fp := res.Data.(map[string]interface{})["filter"].(map[string]interface{})
+	if fp["ampLevels"] != 3 || fp["diffLevels"] != 2 || len(fp["amp"].([]interface{})) != 6 {
+		t.Errorf("unexpected filter params %v", fp)
+	}
+
+	res = d.Query(`mutation { filter(type: "diff", level: 2, tao: 4) }`, nil)
+	if len(res.Errors) == 0 {
+		t.Error("expected an error for a level past the end of the chain")
+	}
+}
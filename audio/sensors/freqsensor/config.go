@@ -7,9 +7,8 @@ import (
 	"reflect"
 	"strings"
 
-	"gonum.org/v1/gonum/mat"
-
 	"github.com/graphql-go/graphql"
+	"github.com/peragwin/vuzicgo/audio/util"
 )
 
 // Running modes
@@ -18,24 +17,38 @@ const (
 	AnimateMode
 )
 
-// Parameters is a set of parameters that control the visualization
+// Parameters is a set of parameters that control the visualization. The ranges and
+// descriptions in the tags are published on the graphql API and enforced by its mutation;
+// see ParamInfo.
 type Parameters struct {
-	GlobalBrightness float64 `json:"gbr"`
-	Brightness       float64 `json:"br"`
+	GlobalBrightness float64 `json:"gbr" yaml:"gbr" min:"0" max:"255" step:"1" default:"127" desc:"brightness of the whole display"`
+	Brightness       float64 `json:"br" yaml:"br" min:"0" max:"16" step:"0.1" default:"4" desc:"how much amplitude saturates the colors"`
 	Direction        int
-	Gain             float64 `json:"gain"`
-	DifferentialGain float64 `json:"diff"`
-	Offset           float64 `json:"offset"`
-	Period           int     `json:"period"`
-	Sync             float64 `json:"sync"`
-	Mode             int     `json:"mode"`
+	Gain             float64 `json:"gain" yaml:"gain" min:"0" max:"20" step:"0.1" default:"2" desc:"gain of the amplitude channel"`
+	DifferentialGain float64 `json:"diff" yaml:"diff" min:"0" max:"0.1" step:"0.0001" default:"0.002" desc:"how fast changes in amplitude advance the phase"`
+	Offset           float64 `json:"offset" yaml:"offset" min:"-10" max:"10" step:"0.1" default:"0" desc:"offset added to the amplitude channel"`
+	Period           int     `json:"period" yaml:"period" min:"1" max:"256" step:"1" default:"24" unit:"columns" desc:"spatial period of the color wave"`
+	Sync             float64 `json:"sync" yaml:"sync" min:"0" max:"1" step:"0.001" default:"0.01" desc:"how strongly the phases of the buckets are pulled together"`
+	Mode             int     `json:"mode" yaml:"mode" default:"1" enum:"normal=0,animate=1" desc:"running mode"`
+
+	WarpOffset float64 `json:"warpOffset" yaml:"warpOffset" min:"0" max:"4" step:"0.01" default:"0.5" desc:"base warp of the rows"`
+	WarpScale  float64 `json:"warpScale" yaml:"warpScale" min:"0" max:"16" step:"0.01" default:"1" desc:"how much the differential warps the rows"`
 
-	WarpOffset float64 `json:"warpOffset"`
-	WarpScale  float64 `json:"warpScale"`
+	Scale float64 `json:"scale" yaml:"scale" min:"0" max:"8" step:"0.01" default:"1" desc:"how much the bass scales the display"`
 
-	Scale float64 `json:"scale"`
+	// The time constants of the amp filter in the lowest and highest buckets, for rising
+	// and falling amplitude. Zero uses the filter's own.
+	AttackLow   float64 `json:"attackLow" yaml:"attackLow" min:"0" max:"20000" step:"1" default:"0" unit:"ms" desc:"time constant of rising amplitude in the lowest bucket"`
+	AttackHigh  float64 `json:"attackHigh" yaml:"attackHigh" min:"0" max:"20000" step:"1" default:"0" unit:"ms" desc:"time constant of rising amplitude in the highest bucket"`
+	ReleaseLow  float64 `json:"releaseLow" yaml:"releaseLow" min:"0" max:"20000" step:"1" default:"0" unit:"ms" desc:"time constant of falling amplitude in the lowest bucket"`
+	ReleaseHigh float64 `json:"releaseHigh" yaml:"releaseHigh" min:"0" max:"20000" step:"1" default:"0" unit:"ms" desc:"time constant of falling amplitude in the highest bucket"`
+	TimeCurve   int     `json:"timeCurve" yaml:"timeCurve" default:"0" enum:"linear=0,log=1" desc:"how the time constants change from the lowest to the highest bucket"`
 
-	Debug bool `json:"debug"`
+	// Zero keeps the per frame behavior that these had before they were given in ms.
+	Decay         float64 `json:"decay" yaml:"decay" min:"0" max:"20000" step:"1" default:"0" unit:"ms" desc:"time constant of the trail in animate mode; 0 fades it over half the display"`
+	BassSmoothing float64 `json:"bassSmoothing" yaml:"bassSmoothing" min:"0" max:"5000" step:"1" default:"30" unit:"ms" desc:"time constant of the lowpass on the bass"`
+
+	Debug bool `json:"debug" yaml:"debug" default:"false" desc:"print debugging output"`
 }
 
 // Config is passed to initialize the module
@@ -43,11 +56,49 @@ type Config struct {
 	Buckets    int
 	Columns    int
 	SampleRate float64
+	// FrameRate is how many frames per second the sensor gets, which it needs to convert
+	// time constants in milliseconds. If it's zero, Process works it out from the size of
+	// the spectrum assuming the blocks don't overlap, and ProcessBuckets assumes 1024
+	// sample blocks at 44.1kHz.
+	FrameRate  float64
 	Parameters *Parameters
+	// Scale is the name of the frequency scale the buckets are spaced on, see
+	// util.ScaleByName. It defaults to "log".
+	Scale string
+	// FMin and FMax are the range of frequencies that get bucketed. They default to 32Hz
+	// and 16kHz.
+	FMin float64
+	FMax float64
+	// Extensions publish fields from other processors on the sensor's graphql API.
+	Extensions []Extension
+	// Presets is the library that presets are saved to and recalled from.
+	Presets *PresetLibrary
+	// SlewTime is the time constant in seconds with which the parameters follow changes.
+	SlewTime float64
+	// Modulations are applied to the parameters every frame. More can be added through the
+	// graphql API.
+	Modulations []Modulation
+	// Bands are the band drivers, which default to DefaultBands. Each is also an audio
+	// feature for the modulations, named with BandFeaturePrefix.
+	Bands []Band
+}
+
+// Extension is implemented by processors that want to add fields to the graphql API.
+type Extension interface {
+	QueryFields() graphql.Fields
+	MutationFields() graphql.Fields
 }
 
 func (d *FrequencySensor) initGraphql() error {
-	paramType, paramMut := NewGraphqlType("ParamType", d.params)
+	paramType, paramMut, err := newGraphqlType("ParamType", reflect.TypeOf(Parameters{}),
+		func(update func(reflect.Value)) interface{} {
+			return d.paramStore.Update(func(p *Parameters) {
+				update(reflect.ValueOf(p).Elem())
+			})
+		})
+	if err != nil {
+		return err
+	}
 
 	filterType := graphql.NewObject(
 		graphql.ObjectConfig{
@@ -56,13 +107,41 @@ func (d *FrequencySensor) initGraphql() error {
 				"amp": &graphql.Field{
 					Type: graphql.NewList(graphql.Float),
 					Resolve: func(graphql.ResolveParams) (interface{}, error) {
-						return d.filterParams.gain.RawMatrix().Data, nil
+						return d.FilterParams("amp")
 					},
 				},
 				"diff": &graphql.Field{
 					Type: graphql.NewList(graphql.Float),
 					Resolve: func(graphql.ResolveParams) (interface{}, error) {
-						return d.filterParams.diff.RawMatrix().Data, nil
+						return d.FilterParams("diff")
+					},
+				},
+				"ampTimes": &graphql.Field{
+					Type:        graphql.NewList(graphql.Float),
+					Description: "the time constant of each level in ms",
+					Resolve: func(graphql.ResolveParams) (interface{}, error) {
+						t := d.loadTuning()
+						return t.filterTimes(t.filter.gain), nil
+					},
+				},
+				"diffTimes": &graphql.Field{
+					Type:        graphql.NewList(graphql.Float),
+					Description: "the time constant of each level in ms",
+					Resolve: func(graphql.ResolveParams) (interface{}, error) {
+						t := d.loadTuning()
+						return t.filterTimes(t.filter.diff), nil
+					},
+				},
+				"ampLevels": &graphql.Field{
+					Type: graphql.Int,
+					Resolve: func(graphql.ResolveParams) (interface{}, error) {
+						return levels(d.loadTuning().filter.gain), nil
+					},
+				},
+				"diffLevels": &graphql.Field{
+					Type: graphql.Int,
+					Resolve: func(graphql.ResolveParams) (interface{}, error) {
+						return levels(d.loadTuning().filter.diff), nil
 					},
 				},
 			},
@@ -74,7 +153,8 @@ func (d *FrequencySensor) initGraphql() error {
 			"type":  &graphql.ArgumentConfig{Type: graphql.String},
 			"level": &graphql.ArgumentConfig{Type: graphql.Int},
 			"gain":  &graphql.ArgumentConfig{Type: graphql.Float},
-			"tao":   &graphql.ArgumentConfig{Type: graphql.Float},
+			"tao":   &graphql.ArgumentConfig{Type: graphql.Float, Description: "time constant in frames"},
+			"ms":    &graphql.ArgumentConfig{Type: graphql.Float, Description: "time constant in ms, instead of tao"},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 			typ, ok := p.Args["type"]
@@ -90,29 +170,32 @@ func (d *FrequencySensor) initGraphql() error {
 			if ok {
 				gain = igain.(float64)
 			} else {
-				var fp []float64
-				if typ.(string) == "amp" {
-					fp = d.filterParams.gain.RawMatrix().Data
-				} else if typ.(string) == "diff" {
-					fp = d.filterParams.diff.RawMatrix().Data
+				fp, err := d.FilterParams(typ.(string))
+				if err != nil {
+					return nil, err
+				}
+				if l := level.(int); l < 0 || 2*l+1 >= len(fp) {
+					return nil, fmt.Errorf("level %d not defined for %s filter with %d levels",
+						l, typ, len(fp)/2)
 				}
 				gain = math.Abs(fp[2*level.(int)]) + math.Abs(fp[2*level.(int)+1])
 			}
+			if ms, ok := p.Args["ms"]; ok {
+				if err := d.SetFilterTime(
+					typ.(string), level.(int), gain, ms.(float64)); err != nil {
+					return nil, err
+				}
+				return d.FilterParams(typ.(string))
+			}
 			tao, ok := p.Args["tao"]
 			if !ok {
-				return nil, errors.New("missing arg: tao")
+				return nil, errors.New("missing arg: tao or ms")
 			}
 			if err := d.SetFilterParams(
 				typ.(string), level.(int), gain, tao.(float64)); err != nil {
 				return nil, err
 			}
-			var ret []float64
-			if typ.(string) == "amp" {
-				ret = d.filterParams.gain.RawMatrix().Data
-			} else if typ.(string) == "diff" {
-				ret = d.filterParams.diff.RawMatrix().Data
-			}
-			return ret, nil
+			return d.FilterParams(typ.(string))
 		},
 	}
 	rawFilterMut := &graphql.Field{
@@ -130,49 +213,437 @@ func (d *FrequencySensor) initGraphql() error {
 			if !ok {
 				return nil, errors.New("missing arg: raw")
 			}
-			rw := r.([]interface{})
-			raw := make([]float64, len(rw))
-			for i := range rw {
-				raw[i] = rw[i].(float64)
-			}
-			if typ.(string) == "amp" {
-				d.filterParams.gain = mat.NewDense(2, 2, raw)
-			} else if typ.(string) == "diff" {
-				d.filterParams.diff = mat.NewDense(2, 2, raw)
+			raw := floatsArg(r)
+			if err := d.SetRawFilter(typ.(string), raw); err != nil {
+				return nil, err
 			}
 			return raw, nil
 		},
 	}
 
-	rootQuery := graphql.NewObject(
+	bucketsType := graphql.NewObject(
 		graphql.ObjectConfig{
-			Name: "RootQuery",
+			Name: "BucketsType",
 			Fields: graphql.Fields{
-				"params": &graphql.Field{
-					Type: paramType,
+				"count": &graphql.Field{
+					Type: graphql.Int,
 					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-						return d.params, nil
+						return p.Source.(*util.Bucketer).Buckets, nil
 					},
 				},
-				"filter": &graphql.Field{
-					Type: filterType,
-					Resolve: func(graphql.ResolveParams) (interface{}, error) {
-						return d.filterValues, nil
+				"scale": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						name, _ := util.ScaleName(p.Source.(*util.Bucketer).Scale)
+						return name, nil
+					},
+				},
+				"edges": &graphql.Field{
+					Type: graphql.NewList(graphql.Float),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(*util.Bucketer).Edges(), nil
+					},
+				},
+				"centers": &graphql.Field{
+					Type: graphql.NewList(graphql.Float),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(*util.Bucketer).Centers(), nil
 					},
 				},
 			},
 		},
 	)
-	rootMut := graphql.NewObject(
+
+	timeConstantsType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "TimeConstantsType",
+			Fields: graphql.Fields{
+				"attack":  &graphql.Field{Type: graphql.NewList(graphql.Float)},
+				"release": &graphql.Field{Type: graphql.NewList(graphql.Float)},
+			},
+		},
+	)
+
+	queryFields := graphql.Fields{
+		"params": &graphql.Field{
+			Type: paramType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return d.paramStore.Load(), nil
+			},
+		},
+		"filter": &graphql.Field{
+			Type: filterType,
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return d.loadTuning(), nil
+			},
+		},
+		"timeConstants": &graphql.Field{
+			Type:        timeConstantsType,
+			Description: "the time constants of each bucket in the last frame",
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return d.TimeConstants(), nil
+			},
+		},
+		"paramInfo": &graphql.Field{
+			Type: graphql.NewList(paramInfoType),
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return ParamInfos(), nil
+			},
+		},
+		"scales": &graphql.Field{
+			Type: graphql.NewList(graphql.String),
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return util.ScaleNames(), nil
+			},
+		},
+		"presets": &graphql.Field{
+			Type: graphql.NewList(graphql.String),
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				if d.presets == nil {
+					return []string{}, nil
+				}
+				return d.presets.Names()
+			},
+		},
+		"preset": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return d.CurrentPreset(), nil
+			},
+		},
+		"buckets": &graphql.Field{
+			Type: bucketsType,
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				proc := d.bucketProcessor()
+				if proc == nil {
+					return nil, errors.New("buckets are not known until processing starts")
+				}
+				return proc.Bucketer(), nil
+			},
+		},
+	}
+	timeConstantsMut := &graphql.Field{
+		Type:        timeConstantsType,
+		Description: "override the time constant curves with lists spread evenly over the buckets",
+		Args: graphql.FieldConfigArgument{
+			"attack":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.Float)},
+			"release": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.Float)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			tc, err := d.updateTimeConstants(func(tc *TimeConstants) {
+				if a, ok := p.Args["attack"]; ok {
+					tc.Attack = floatsArg(a)
+				}
+				if r, ok := p.Args["release"]; ok {
+					tc.Release = floatsArg(r)
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			return tc, nil
+		},
+	}
+	bucketsMut := &graphql.Field{
+		Type: bucketsType,
+		Args: graphql.FieldConfigArgument{
+			"count": &graphql.ArgumentConfig{Type: graphql.Int},
+			"scale": &graphql.ArgumentConfig{Type: graphql.String},
+			"fMin":  &graphql.ArgumentConfig{Type: graphql.Float},
+			"fMax":  &graphql.ArgumentConfig{Type: graphql.Float},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var scale util.Scale
+			if name, ok := p.Args["scale"]; ok {
+				var err error
+				if scale, err = util.ScaleByName(name.(string)); err != nil {
+					return nil, err
+				}
+			}
+			err := d.RebuildBuckets(func(cfg *util.BucketerConfig) {
+				if count, ok := p.Args["count"]; ok {
+					cfg.Buckets = count.(int)
+				}
+				if scale != nil {
+					cfg.Scale = scale
+				}
+				if fMin, ok := p.Args["fMin"]; ok {
+					cfg.FMin = fMin.(float64)
+				}
+				if fMax, ok := p.Args["fMax"]; ok {
+					cfg.FMax = fMax.(float64)
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			return d.bucketProcessor().Bucketer(), nil
+		},
+	}
+
+	savePresetMut := &graphql.Field{
+		Type: graphql.String,
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			name := p.Args["name"].(string)
+			if err := d.SavePreset(name); err != nil {
+				return nil, err
+			}
+			return name, nil
+		},
+	}
+	recallPresetMut := &graphql.Field{
+		Type: paramType,
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			"fade": &graphql.ArgumentConfig{
+				Type:        graphql.Float,
+				Description: "seconds to crossfade from the current parameters",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			fade, _ := p.Args["fade"].(float64)
+			if err := d.RecallPreset(p.Args["name"].(string), fade); err != nil {
+				return nil, err
+			}
+			return d.paramStore.Load(), nil
+		},
+	}
+
+	modFields, modInputFields := modulationFields()
+	modulationType := graphql.NewObject(
 		graphql.ObjectConfig{
-			Name: "RootMut",
+			Name:   "ModulationType",
+			Fields: modFields,
+		},
+	)
+	sceneType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "SceneType",
 			Fields: graphql.Fields{
-				"params":    paramMut,
-				"filter":    filterMut,
-				"rawFilter": rawFilterMut,
+				"playlist": &graphql.Field{Type: graphql.String},
+				"index":    &graphql.Field{Type: graphql.Int},
+				"preset":   &graphql.Field{Type: graphql.String},
+				"elapsed":  &graphql.Field{Type: graphql.Float},
+				"beats":    &graphql.Field{Type: graphql.Int},
+				"held":     &graphql.Field{Type: graphql.Boolean},
 			},
 		},
 	)
+	scheduler := func() (*Scheduler, error) {
+		s := d.Scheduler()
+		if s == nil {
+			return nil, errors.New("no scheduler is running")
+		}
+		return s, nil
+	}
+	queryFields["scene"] = &graphql.Field{
+		Type: sceneType,
+		Resolve: func(graphql.ResolveParams) (interface{}, error) {
+			s, err := scheduler()
+			if err != nil {
+				return nil, err
+			}
+			return s.Current(), nil
+		},
+	}
+	skipSceneMut := &graphql.Field{
+		Type: sceneType,
+		Resolve: func(graphql.ResolveParams) (interface{}, error) {
+			s, err := scheduler()
+			if err != nil {
+				return nil, err
+			}
+			if err := s.Skip(); err != nil {
+				return nil, err
+			}
+			return s.Current(), nil
+		},
+	}
+	holdSceneMut := &graphql.Field{
+		Type: sceneType,
+		Args: graphql.FieldConfigArgument{
+			"hold": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			s, err := scheduler()
+			if err != nil {
+				return nil, err
+			}
+			s.Hold(p.Args["hold"].(bool))
+			return s.Current(), nil
+		},
+	}
+
+	bandFields, bandInputFields := bandFields()
+	bandType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name:   "BandType",
+			Fields: bandFields,
+		},
+	)
+	queryFields["bands"] = &graphql.Field{
+		Type: graphql.NewList(bandType),
+		Resolve: func(graphql.ResolveParams) (interface{}, error) {
+			return d.bands.States(), nil
+		},
+	}
+	setBandMut := &graphql.Field{
+		Type: graphql.NewList(bandType),
+		Args: graphql.FieldConfigArgument{
+			"band": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewInputObject(
+					graphql.InputObjectConfig{
+						Name:   "inputBand",
+						Fields: bandInputFields,
+					},
+				)),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			band, err := bandFromArgs(p.Args["band"].(map[string]interface{}))
+			if err != nil {
+				return nil, err
+			}
+			if err := d.bands.Set(band); err != nil {
+				return nil, err
+			}
+			return d.bands.States(), nil
+		},
+	}
+	removeBandMut := &graphql.Field{
+		Type: graphql.Boolean,
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return d.bands.Remove(p.Args["name"].(string)), nil
+		},
+	}
+
+	queryFields["frameRate"] = &graphql.Field{
+		Type:        graphql.Float,
+		Description: "frames per second, which time constants in ms are converted with",
+		Resolve: func(graphql.ResolveParams) (interface{}, error) {
+			return d.FrameRate(), nil
+		},
+	}
+	queryFields["slew"] = &graphql.Field{
+		Type: graphql.Float,
+		Resolve: func(graphql.ResolveParams) (interface{}, error) {
+			return d.slewer.Time(), nil
+		},
+	}
+	slewMut := &graphql.Field{
+		Type: graphql.Float,
+		Args: graphql.FieldConfigArgument{
+			"time": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Float)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			if err := d.slewer.SetTime(p.Args["time"].(float64)); err != nil {
+				return nil, err
+			}
+			return d.slewer.Time(), nil
+		},
+	}
+	queryFields["modulations"] = &graphql.Field{
+		Type: graphql.NewList(modulationType),
+		Resolve: func(graphql.ResolveParams) (interface{}, error) {
+			return d.modulators.States(), nil
+		},
+	}
+	setModulationMut := &graphql.Field{
+		Type: graphql.NewList(modulationType),
+		Args: graphql.FieldConfigArgument{
+			"mod": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewInputObject(
+					graphql.InputObjectConfig{
+						Name:   "inputModulation",
+						Fields: modInputFields,
+					},
+				)),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			mod, err := modulationFromArgs(p.Args["mod"].(map[string]interface{}))
+			if err != nil {
+				return nil, err
+			}
+			if err := d.modulators.Set(mod); err != nil {
+				return nil, err
+			}
+			return d.modulators.States(), nil
+		},
+	}
+	removeModulationMut := &graphql.Field{
+		Type: graphql.Boolean,
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return d.modulators.Remove(p.Args["name"].(string)), nil
+		},
+	}
+	triggerMut := &graphql.Field{
+		Type: graphql.Boolean,
+		Args: graphql.FieldConfigArgument{
+			"event": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			d.modulators.Trigger(p.Args["event"].(string))
+			return true, nil
+		},
+	}
+
+	mutFields := graphql.Fields{
+		"params":        paramMut,
+		"filter":        filterMut,
+		"rawFilter":     rawFilterMut,
+		"timeConstants": timeConstantsMut,
+		"buckets":       bucketsMut,
+		"savePreset":    savePresetMut,
+		"recallPreset":  recallPresetMut,
+
+		"setModulation":    setModulationMut,
+		"removeModulation": removeModulationMut,
+		"trigger":          triggerMut,
+		"slew":             slewMut,
+		"setBand":          setBandMut,
+		"removeBand":       removeBandMut,
+
+		"skipScene": skipSceneMut,
+		"holdScene": holdSceneMut,
+	}
+	vgcQuery, vgcMut := d.vgcGraphql()
+	if err := mergeFields(queryFields, vgcQuery); err != nil {
+		return err
+	}
+	if err := mergeFields(mutFields, vgcMut); err != nil {
+		return err
+	}
+	for _, ext := range d.extensions {
+		if err := mergeFields(queryFields, ext.QueryFields()); err != nil {
+			return err
+		}
+		if err := mergeFields(mutFields, ext.MutationFields()); err != nil {
+			return err
+		}
+	}
+
+	rootQuery := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name:   "RootQuery",
+			Fields: queryFields,
+		},
+	)
+	rootMut := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name:   "RootMut",
+			Fields: mutFields,
+		},
+	)
 	schema, err := graphql.NewSchema(
 		graphql.SchemaConfig{
 			Query:    rootQuery,
@@ -186,6 +657,26 @@ func (d *FrequencySensor) initGraphql() error {
 	return nil
 }
 
+// floatsArg converts a list of floats from graphql arguments.
+func floatsArg(arg interface{}) []float64 {
+	list := arg.([]interface{})
+	floats := make([]float64, len(list))
+	for i := range list {
+		floats[i] = list[i].(float64)
+	}
+	return floats
+}
+
+func mergeFields(dst, src graphql.Fields) error {
+	for name, f := range src {
+		if _, ok := dst[name]; ok {
+			return fmt.Errorf("graphql field %q is already defined", name)
+		}
+		dst[name] = f
+	}
+	return nil
+}
+
 func (d *FrequencySensor) Query(query string, vars map[string]interface{}) *graphql.Result {
 	return graphql.Do(graphql.Params{
 		Schema:         d.schema,
@@ -196,50 +687,59 @@ func (d *FrequencySensor) Query(query string, vars map[string]interface{}) *grap
 
 // NewGraphqlType expects a pointer type for val
 func NewGraphqlType(name string, val interface{}) (*graphql.Object, *graphql.Field) {
+	elem := reflect.ValueOf(val).Elem()
+	typ, mut, err := newGraphqlType(name, elem.Type(), func(update func(reflect.Value)) interface{} {
+		update(elem)
+		return elem.Addr().Interface()
+	})
+	if err != nil {
+		panic(err)
+	}
+	return typ, mut
+}
+
+// newGraphqlType creates the type and mutation for a struct of type @ref, described by the
+// metadata in its struct tags (see ParamInfo). The mutation validates its arguments against
+// the metadata, then passes a function that sets the fields to @apply, which returns the
+// updated struct.
+func newGraphqlType(name string, ref reflect.Type,
+	apply func(update func(reflect.Value)) interface{}) (*graphql.Object, *graphql.Field, error) {
+
 	fields := graphql.Fields{}
 	inputFields := graphql.InputObjectConfigFieldMap{}
-	mutArgs := graphql.FieldConfigArgument{}
 
-	elem := reflect.ValueOf(val).Elem()
-	tagMap := newJSONTagFieldMap(elem)
-	ref := elem.Type()
+	infos, err := paramInfos(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	infoMap := make(map[string]*ParamInfo, len(infos))
 
-	resolver := func(tag string) func(graphql.ResolveParams) (interface{}, error) {
-		field, ok := tagMap[tag]
-		if !ok {
-			panic("unknown tag: " + tag)
-		}
+	resolver := func(field int) func(graphql.ResolveParams) (interface{}, error) {
 		return func(p graphql.ResolveParams) (interface{}, error) {
-			if params, ok := p.Source.(*Parameters); ok {
-				ref := reflect.ValueOf(params).Elem()
-				val := ref.Field(field)
-				return val.Interface(), nil
+			src := reflect.ValueOf(p.Source)
+			if src.Kind() != reflect.Ptr || src.Elem().Type() != ref {
+				return nil, fmt.Errorf("something when wrong: %#v", p.Source)
 			}
-			return nil, fmt.Errorf("something when wrong: %#v", p.Source)
+			return src.Elem().Field(field).Interface(), nil
 		}
 	}
 
-	for tag, i := range tagMap {
-		if tag == "" {
-			continue
-		}
-		f := ref.Field(i)
+	for _, info := range infos {
 		var typ graphql.Type
-		switch f.Type.Kind() {
-		case reflect.Bool:
+		switch info.Type {
+		case "Boolean":
 			typ = graphql.Boolean
-		case reflect.Float32, reflect.Float64:
+		case "Float":
 			typ = graphql.Float
-		case reflect.String:
+		case "String":
 			typ = graphql.String
-		case reflect.Int, reflect.Int8, reflect.Int32, reflect.Int64:
+		case "Int":
 			typ = graphql.Int
-		default:
-			panic(fmt.Sprint("unsupported type", f.Type))
 		}
-		fields[tag] = &graphql.Field{Type: typ, Resolve: resolver(tag)}
-		inputFields[tag] = &graphql.InputObjectFieldConfig{Type: typ}
-		mutArgs[tag] = &graphql.ArgumentConfig{Type: typ}
+		desc := info.describe()
+		infoMap[info.Name] = info
+		fields[info.Name] = &graphql.Field{Type: typ, Description: desc, Resolve: resolver(info.field)}
+		inputFields[info.Name] = &graphql.InputObjectFieldConfig{Type: typ, Description: desc}
 	}
 
 	paramType := graphql.NewObject(
@@ -260,14 +760,19 @@ func NewGraphqlType(name string, val interface{}) (*graphql.Object, *graphql.Fie
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 			params := p.Args["params"].(map[string]interface{})
 			for arg, val := range params {
-				field := tagMap[arg]
-				elem.Field(field).Set(reflect.ValueOf(val))
+				if err := infoMap[arg].Validate(val); err != nil {
+					return nil, err
+				}
 			}
-			return elem.Addr().Interface(), nil
+			return apply(func(elem reflect.Value) {
+				for arg, val := range params {
+					elem.Field(infoMap[arg].field).Set(reflect.ValueOf(val))
+				}
+			}), nil
 		},
 	}
 
-	return paramType, paramMut
+	return paramType, paramMut, nil
 }
 
 // NewGraphqlMutationFields expects a pointer type
@@ -275,21 +780,7 @@ func NewGraphqlType(name string, val interface{}) (*graphql.Object, *graphql.Fie
 // 	return nil
 // }
 
-func getJSONTag(ref reflect.Value, i int) string {
-	f := ref.Type().Field(i)
-	return jsonTag(&f)
-}
-
 func jsonTag(f *reflect.StructField) string {
 	t := f.Tag.Get("json")
 	return strings.Split(t, ",")[0]
 }
-
-func newJSONTagFieldMap(ref reflect.Value) map[string]int {
-	m := make(map[string]int)
-	for i := 0; i < ref.NumField(); i++ {
-		tag := getJSONTag(ref, i)
-		m[tag] = i
-	}
-	return m
-}
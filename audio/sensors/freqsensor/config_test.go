@@ -2,6 +2,8 @@ package freqsensor
 
 import (
 	"testing"
+
+	"github.com/graphql-go/graphql"
 )
 
 // func TestGraphql(t *testing.T) {
@@ -70,6 +72,65 @@ import (
 // }
 
 func TestNewFields(t *testing.T) {
-	typ, mut := NewGraphqlType("params", Parameters{})
-	t.Fatal(typ, mut)
+	params := *DefaultParameters
+	typ, mut := NewGraphqlType("params", &params)
+	for _, name := range []string{"gain", "mode", "period"} {
+		if _, ok := typ.Fields()[name]; !ok {
+			t.Errorf("expected a %s field", name)
+		}
+	}
+
+	res, err := mut.Resolve(graphql.ResolveParams{Args: map[string]interface{}{
+		"params": map[string]interface{}{"gain": 3.0},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.(*Parameters).Gain != 3 || params.Gain != 3 {
+		t.Error("expected the mutation to set the gain")
+	}
+
+	_, err = mut.Resolve(graphql.ResolveParams{Args: map[string]interface{}{
+		"params": map[string]interface{}{"gain": 30.0},
+	}})
+	if err == nil {
+		t.Error("expected an error for a gain out of range")
+	}
+}
+
+func TestRebuildBucketCount(t *testing.T) {
+	d := newTestSensor(t, nil)
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64, 1)
+	defer close(in)
+	in <- make([]float64, 512)
+	out := d.Process(done, in)
+
+	// the buckets are changed and read while frames go through
+	frames := make(chan *Drivers)
+	go func() {
+		defer close(frames)
+		var drv *Drivers
+		for i := 0; i < 50; i++ {
+			in <- make([]float64, 512)
+			drv = <-out
+		}
+		frames <- drv
+	}()
+	res := d.Query(`mutation { buckets(count: 8) { count edges } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	buckets := res.Data.(map[string]interface{})["buckets"].(map[string]interface{})
+	if buckets["count"] != 8 || len(buckets["edges"].([]interface{})) != 9 {
+		t.Errorf("unexpected buckets %v", buckets)
+	}
+	<-frames
+
+	in <- make([]float64, 512)
+	drv := <-out
+	if len(drv.Diff) != 8 || len(drv.Amplitude[0]) != 8 || len(drv.Energy) != 8 {
+		t.Errorf("expected 8 buckets, got %d", len(drv.Diff))
+	}
 }
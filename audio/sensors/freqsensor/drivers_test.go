@@ -0,0 +1,49 @@
+package freqsensor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDriverSnapshots(t *testing.T) {
+	d := newTestSensor(t, nil)
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64)
+	defer close(in)
+	out := d.ProcessBuckets(done, in)
+
+	frame := func(level float64) *Drivers {
+		x := make([]float64, 16)
+		for i := range x {
+			x[i] = level
+		}
+		in <- x
+		return <-out
+	}
+
+	start := time.Now()
+	first := frame(1)
+	amp := copyFloats(first.Amplitude[0])
+	d.Parameters().Update(func(p *Parameters) { p.Gain = 4 })
+	second := frame(2)
+
+	for i, v := range first.Amplitude[0] {
+		if v != amp[i] {
+			t.Fatal("expected a snapshot not to change after it's sent")
+		}
+	}
+	if first.Seq != 0 || second.Seq != 1 {
+		t.Errorf("unexpected sequence numbers %d, %d", first.Seq, second.Seq)
+	}
+	if first.SamplePosition != 1024 || second.SamplePosition != 2048 {
+		t.Errorf("unexpected sample positions %d, %d", first.SamplePosition, second.SamplePosition)
+	}
+	if first.Time.Before(start) || second.Time.Before(first.Time) {
+		t.Errorf("unexpected times %v, %v", first.Time, second.Time)
+	}
+	if second.ParamVersion != first.ParamVersion+1 {
+		t.Errorf("expected the parameter version to go up by one, got %d, %d",
+			first.ParamVersion, second.ParamVersion)
+	}
+}
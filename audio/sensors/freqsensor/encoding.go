@@ -0,0 +1,244 @@
+package freqsensor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DriverFields selects the fields of Drivers that get encoded. The timing fields are
+// always included.
+type DriverFields uint8
+
+// Fields of Drivers
+const (
+	AmplitudeField DriverFields = 1 << iota
+	DiffField
+	EnergyField
+	BassField
+	BandsField
+
+	AllFields = AmplitudeField | DiffField | EnergyField | BassField | BandsField
+)
+
+var driverFieldNames = []struct {
+	name  string
+	field DriverFields
+}{
+	{"amplitude", AmplitudeField},
+	{"diff", DiffField},
+	{"energy", EnergyField},
+	{"bass", BassField},
+	{"bands", BandsField},
+}
+
+// ParseDriverFields parses a comma separated list of field names, e.g. "amplitude,bass".
+// An empty list selects all of them.
+func ParseDriverFields(s string) (DriverFields, error) {
+	if strings.TrimSpace(s) == "" {
+		return AllFields, nil
+	}
+	var fields DriverFields
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, f := range driverFieldNames {
+			if f.name == name {
+				fields |= f.field
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown drivers field %q", name)
+		}
+	}
+	return fields, nil
+}
+
+// MarshalDriversJSON encodes @fields of @drv as a JSON object.
+func MarshalDriversJSON(drv *Drivers, fields DriverFields) ([]byte, error) {
+	m := map[string]interface{}{
+		"seq":            drv.Seq,
+		"samplePosition": drv.SamplePosition,
+		"time":           drv.Time,
+		"paramVersion":   drv.ParamVersion,
+	}
+	if fields&AmplitudeField != 0 {
+		m["amplitude"] = drv.Amplitude
+	}
+	if fields&DiffField != 0 {
+		m["diff"] = drv.Diff
+	}
+	if fields&EnergyField != 0 {
+		m["energy"] = drv.Energy
+	}
+	if fields&BassField != 0 {
+		m["bass"] = drv.Bass
+	}
+	if fields&BandsField != 0 {
+		m["bands"] = drv.Bands
+	}
+	return json.Marshal(m)
+}
+
+// driversVersion is the version of the binary encoding.
+const driversVersion = 1
+
+// AppendDriversBinary appends the compact binary encoding of @fields of @drv to @buf.
+// Values are little endian and the floats are float32s:
+//
+//	u8  version, currently 1
+//	u8  fields
+//	u16 buckets
+//	u16 rows of amplitude
+//	u16 bands
+//	u64 seq
+//	i64 sample position
+//	i64 time in ns since the Unix epoch
+//	u64 parameter version
+//	    rows*buckets amplitudes, buckets diffs, buckets energies and the bass, as selected
+//	    for each band in name order: u8 length of the name, the name, and the value
+func AppendDriversBinary(buf []byte, drv *Drivers, fields DriverFields) []byte {
+	var names []string
+	if fields&BandsField != 0 {
+		for name := range drv.Bands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	rows := 0
+	if fields&AmplitudeField != 0 {
+		rows = len(drv.Amplitude)
+	}
+	buckets := len(drv.Diff)
+
+	le := binary.LittleEndian
+	buf = append(buf, driversVersion, byte(fields))
+	buf = le.AppendUint16(buf, uint16(buckets))
+	buf = le.AppendUint16(buf, uint16(rows))
+	buf = le.AppendUint16(buf, uint16(len(names)))
+	buf = le.AppendUint64(buf, drv.Seq)
+	buf = le.AppendUint64(buf, uint64(drv.SamplePosition))
+	buf = le.AppendUint64(buf, uint64(drv.Time.UnixNano()))
+	buf = le.AppendUint64(buf, drv.ParamVersion)
+
+	appendFloats := func(values []float64) {
+		for _, v := range values {
+			buf = le.AppendUint32(buf, math.Float32bits(float32(v)))
+		}
+	}
+	if fields&AmplitudeField != 0 {
+		for _, row := range drv.Amplitude {
+			appendFloats(row)
+		}
+	}
+	if fields&DiffField != 0 {
+		appendFloats(drv.Diff)
+	}
+	if fields&EnergyField != 0 {
+		appendFloats(drv.Energy)
+	}
+	if fields&BassField != 0 {
+		appendFloats([]float64{drv.Bass})
+	}
+	for _, name := range names {
+		if len(name) > 255 {
+			name = name[:255]
+		}
+		buf = append(buf, byte(len(name)))
+		buf = append(buf, name...)
+		appendFloats([]float64{drv.Bands[name]})
+	}
+	return buf
+}
+
+// driversHeaderSize is the size of the fixed part of the binary encoding.
+const driversHeaderSize = 2 + 3*2 + 4*8
+
+var errShortDrivers = errors.New("drivers: encoding is too short")
+
+// ReadDriversBinary decodes drivers that were encoded by AppendDriversBinary from the
+// start of @data. It returns the drivers, the fields that were encoded, and the number of
+// bytes that were read.
+func ReadDriversBinary(data []byte) (*Drivers, DriverFields, int, error) {
+	if len(data) < driversHeaderSize {
+		return nil, 0, 0, errShortDrivers
+	}
+	if data[0] != driversVersion {
+		return nil, 0, 0, fmt.Errorf("drivers: unknown encoding version %d", data[0])
+	}
+	le := binary.LittleEndian
+	fields := DriverFields(data[1])
+	buckets := int(le.Uint16(data[2:]))
+	rows := int(le.Uint16(data[4:]))
+	bands := int(le.Uint16(data[6:]))
+	drv := &Drivers{
+		Seq:            le.Uint64(data[8:]),
+		SamplePosition: int64(le.Uint64(data[16:])),
+		Time:           time.Unix(0, int64(le.Uint64(data[24:]))),
+		ParamVersion:   le.Uint64(data[32:]),
+		Bands:          make(map[string]float64, bands),
+	}
+	pos := driversHeaderSize
+
+	readFloats := func(n int) ([]float64, error) {
+		if len(data) < pos+4*n {
+			return nil, errShortDrivers
+		}
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = float64(math.Float32frombits(le.Uint32(data[pos:])))
+			pos += 4
+		}
+		return values, nil
+	}
+	var err error
+	if fields&AmplitudeField != 0 {
+		drv.Amplitude = make([][]float64, rows)
+		for i := range drv.Amplitude {
+			if drv.Amplitude[i], err = readFloats(buckets); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+	}
+	if fields&DiffField != 0 {
+		if drv.Diff, err = readFloats(buckets); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if fields&EnergyField != 0 {
+		if drv.Energy, err = readFloats(buckets); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if fields&BassField != 0 {
+		bass, err := readFloats(1)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		drv.Bass = bass[0]
+	}
+	for i := 0; i < bands; i++ {
+		if len(data) < pos+1 {
+			return nil, 0, 0, errShortDrivers
+		}
+		n := int(data[pos])
+		pos++
+		if len(data) < pos+n {
+			return nil, 0, 0, errShortDrivers
+		}
+		name := string(data[pos : pos+n])
+		pos += n
+		v, err := readFloats(1)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		drv.Bands[name] = v[0]
+	}
+	return drv, fields, pos, nil
+}
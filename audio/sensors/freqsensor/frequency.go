@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/graphql-go/graphql"
 	"github.com/peragwin/vuzicgo/audio/util"
@@ -25,22 +28,13 @@ var (
 	}
 	defaultVGCParams = []float64{0.05, 0.95}
 
-	// DefaultParameters is a set of default parameters that work okay
-	DefaultParameters = &Parameters{
-		GlobalBrightness: 127,
-		Brightness:       4,
-		Offset:           0,
-		Period:           24,
-		Gain:             2,
-		DifferentialGain: 2e-3,
-		Sync:             1e-2,
-		Mode:             AnimateMode,
-		WarpOffset:       0.5,
-		WarpScale:        1.0,
-		Scale:            1.0,
-	}
+	// DefaultParameters is a set of default parameters that work okay. They're the
+	// defaults in the tags of Parameters.
+	DefaultParameters = tagDefaults()
 )
 
+// Drivers is the output of a FrequencySensor for a frame. The ones the sensor sends are
+// snapshots that it doesn't change afterwards, so they must not be modified.
 type Drivers struct {
 	// Amplitude is the immediate amplitudes of the frequency response for each frame
 	Amplitude [][]float64
@@ -50,48 +44,166 @@ type Drivers struct {
 	Energy []float64
 	// Bass keeps track of how intense the current base is
 	Bass float64
+	// Bands holds the value of each band driver by name
+	Bands map[string]float64
+
+	// Seq numbers the frames from 0.
+	Seq uint64
+	// SamplePosition is how many samples of audio the sensor had taken in by the end of
+	// the frame.
+	SamplePosition int64
+	// Time is when the frame reached the sensor.
+	Time time.Time
+	// ParamVersion is the version of the parameters the frame was made with, see
+	// ParameterStore.LoadVersion.
+	ParamVersion uint64
+}
+
+// Copy returns a deep copy of the drivers.
+func (d *Drivers) Copy() *Drivers {
+	c := *d
+	c.Amplitude = make([][]float64, len(d.Amplitude))
+	for i, row := range d.Amplitude {
+		c.Amplitude[i] = copyFloats(row)
+	}
+	c.Diff = copyFloats(d.Diff)
+	c.Energy = copyFloats(d.Energy)
+	c.Bands = make(map[string]float64, len(d.Bands))
+	for k, v := range d.Bands {
+		c.Bands[k] = v
+	}
+	return &c
 }
 
 // FrequencySensor is the main object that generate the visualization
 type FrequencySensor struct {
-	Frames  int
-	Buckets int
+	Frames     int
+	Buckets    int
+	SampleRate float64
 
 	Drivers
 
+	// params and filterParams are the snapshots used for the current frame. They're only
+	// touched by the processing goroutine; changes go through paramStore and tuning.
 	params       *Parameters
 	filterParams filterValues
+	paramStore   *ParameterStore
+	tuning       atomic.Value // *tuning
+	// tuneLock serializes writers of tuning and guards preset and scheduler. Frames take
+	// their snapshots under it, so a writer that holds it can change both atomically.
+	tuneLock    sync.Mutex
+	lastTuning  *tuning
+	slewer      *Slewer
+	modulators  *Modulators
+	frameParams atomic.Value // *Parameters, the modulated snapshot of the current frame
+	lastFrame   time.Time
+
+	bucketCfg    util.BucketerConfig
+	bucketProc   atomic.Value // *util.BucketProcessor, set once Process starts
 	filterValues filterValues
+	times        *bucketTimes
+	timesOut     atomic.Value // *bucketTimes, for the graphql API
 	vgc          *variableGainController
+	gainHistory  gainHistory
 	preemphasis  float64
 
-	schema graphql.Schema
+	// deriveRate is set if the frame rate should be worked out from the input
+	deriveRate bool
+
+	schema     graphql.Schema
+	extensions []Extension
+
+	presets   *PresetLibrary
+	preset    string
+	scheduler *Scheduler
+	beats     beatDetector
+	bands     *Bands
 
 	frameCount int
+	// samples is how many samples of audio the frames so far cover
+	samples float64
 }
 
 // NewFrequencySensor creates a new FrequencySensor from a Config
 func NewFrequencySensor(cfg *Config) *FrequencySensor {
+	scaleName := cfg.Scale
+	if scaleName == "" {
+		scaleName = "log"
+	}
+	scale, err := util.ScaleByName(scaleName)
+	if err != nil {
+		panic(err)
+	}
+	bucketCfg := util.BucketerConfig{
+		Scale:      scale,
+		Buckets:    cfg.Buckets,
+		SampleRate: cfg.SampleRate,
+		FMin:       cfg.FMin,
+		FMax:       cfg.FMax,
+	}
+	if bucketCfg.FMin == 0 {
+		bucketCfg.FMin = 32
+	}
+	if bucketCfg.FMax == 0 {
+		bucketCfg.FMax = 16000
+	}
+
 	amp := make([][]float64, cfg.Columns)
 	for i := range amp {
 		amp[i] = make([]float64, cfg.Buckets)
 	}
 	fs := &FrequencySensor{
-		Frames:  cfg.Columns,
-		Buckets: cfg.Buckets,
+		Frames:     cfg.Columns,
+		Buckets:    cfg.Buckets,
+		SampleRate: cfg.SampleRate,
 		Drivers: Drivers{
 			Amplitude: amp,
 			Energy:    make([]float64, cfg.Buckets),
 			Diff:      make([]float64, cfg.Buckets),
+			Bands:     make(map[string]float64),
 		},
-		params:       cfg.Parameters,
-		filterParams: defaultFilterParams,
-		filterValues: filterValues{
-			gain: mat.NewDense(2, cfg.Buckets, nil),
-			diff: mat.NewDense(2, cfg.Buckets, nil),
+		paramStore:   NewParameterStore(cfg.Parameters),
+		bucketCfg:    bucketCfg,
+		filterValues: newFilterValues(defaultFilterParams, cfg.Buckets),
+		vgc:          newVariableGainController(cfg.Buckets, defaultVGCParams),
+		preemphasis:  16,
+		extensions:   cfg.Extensions,
+		presets:      cfg.Presets,
+	}
+	fs.tuning.Store(&tuning{
+		filter: defaultFilterParams,
+		vgc: vgcParams{
+			filter:  mat.NewVecDense(2, defaultVGCParams),
+			kp:      1,
+			kd:      16,
+			target:  1,
+			maxGain: 10000,
+			curve:   QuadraticVGCCurve,
 		},
-		vgc:         newVariableGainController(cfg.Buckets, defaultVGCParams),
-		preemphasis: 16,
+		frameRate: defaultFrameRate,
+	})
+	if cfg.FrameRate > 0 {
+		if err := fs.SetFrameRate(cfg.FrameRate); err != nil {
+			panic(err)
+		}
+	} else {
+		fs.deriveRate = true
+	}
+	fs.params = fs.paramStore.Load()
+	fs.frameParams.Store(fs.params)
+	fs.updateTimes(fs.loadTuning())
+	if fs.slewer, err = NewSlewer(cfg.SlewTime); err != nil {
+		panic(err)
+	}
+	if fs.modulators, err = NewModulators(cfg.Modulations); err != nil {
+		panic(err)
+	}
+	bands := cfg.Bands
+	if bands == nil {
+		bands = DefaultBands
+	}
+	if fs.bands, err = NewBands(bands); err != nil {
+		panic(err)
 	}
 	if err := fs.initGraphql(); err != nil {
 		panic(err)
@@ -103,9 +215,29 @@ func NewFrequencySensor(cfg *Config) *FrequencySensor {
 func (d *FrequencySensor) Process(done chan struct{}, in chan []float64) chan *Drivers {
 
 	x := <-in
-	bucketer := util.NewBucketer(util.LogScale, d.Buckets, len(x), 32, 16000)
-	buckets := util.NewBucketProcessor(bucketer).Process(done, in)
+	cfg := d.bucketCfg
+	cfg.Size = len(x)
+	if d.deriveRate && d.SampleRate > 0 {
+		// assume the spectrum is half of an FFT of blocks that don't overlap
+		if err := d.SetFrameRate(d.SampleRate / float64(2*len(x))); err != nil {
+			panic(err)
+		}
+		d.deriveRate = false
+	}
+	bucketer, err := util.NewBucketerFromConfig(cfg)
+	if err != nil {
+		panic(err)
+	}
+	proc := util.NewBucketProcessor(bucketer)
+	d.bucketProc.Store(proc)
+	buckets := proc.Process(done, in)
 
+	return d.ProcessBuckets(done, buckets)
+}
+
+// ProcessBuckets generates the frames of the visualization from input that has already
+// been split into d.Buckets buckets, such as the output of a MultiResolutionProcessor.
+func (d *FrequencySensor) ProcessBuckets(done chan struct{}, buckets chan []float64) chan *Drivers {
 	out := make(chan *Drivers)
 
 	// set up a goroutine to process the bucketed input
@@ -123,6 +255,10 @@ func (d *FrequencySensor) Process(done chan struct{}, in chan []float64) chan *D
 			if x == nil {
 				return
 			}
+			if len(x) != d.Buckets {
+				d.resize(len(x))
+			}
+			d.loadSnapshots()
 
 			d.applyPreemphasis(x)
 
@@ -130,16 +266,186 @@ func (d *FrequencySensor) Process(done chan struct{}, in chan []float64) chan *D
 			d.applyChannelEffects()
 			d.applyChannelSync()
 			d.applyBase(d.Diff)
+			d.applyBands()
+			if d.beats.push(d.Bass) {
+				d.beat()
+			}
 
+			d.stamp()
 			d.frameCount++
 
-			out <- &d.Drivers
+			out <- d.Drivers.Copy()
 		}
 	}()
 
 	return out
 }
 
+// RebuildBuckets changes how the spectrum is bucketed while the sensor runs. The change
+// takes effect on the next frame. If the number of buckets changes, the filters and gain
+// controller start over.
+func (d *FrequencySensor) RebuildBuckets(update func(*util.BucketerConfig)) error {
+	proc := d.bucketProcessor()
+	if proc == nil {
+		return errors.New("buckets can not be rebuilt until processing starts")
+	}
+	return proc.Rebuild(update)
+}
+
+// bucketProcessor returns the processor that buckets the spectrum, or nil until Process
+// starts or if the input is already bucketed.
+func (d *FrequencySensor) bucketProcessor() *util.BucketProcessor {
+	proc, _ := d.bucketProc.Load().(*util.BucketProcessor)
+	return proc
+}
+
+// resize changes the number of buckets the sensor works with.
+func (d *FrequencySensor) resize(buckets int) {
+	d.Buckets = buckets
+	for i := range d.Amplitude {
+		d.Amplitude[i] = make([]float64, buckets)
+	}
+	d.Energy = make([]float64, buckets)
+	d.Diff = make([]float64, buckets)
+	// the first frame may not have loaded the filters yet
+	d.filterValues = newFilterValues(d.loadTuning().filter, buckets)
+	d.vgc = newVariableGainController(buckets, defaultVGCParams)
+	// the new controller still needs the current settings
+	d.lastTuning = nil
+}
+
+// tuning is a snapshot of the filter coefficients and the gain controller's settings. Like
+// a Parameters snapshot, it's replaced as a whole rather than modified.
+type tuning struct {
+	filter filterValues
+	vgc    vgcParams
+	times  TimeConstants
+	// frameRate is the frame rate that the filter and vgc coefficients are for
+	frameRate float64
+}
+
+// Parameters returns the store that holds the sensor's parameters.
+func (d *FrequencySensor) Parameters() *ParameterStore {
+	return d.paramStore
+}
+
+func (d *FrequencySensor) loadTuning() *tuning {
+	return d.tuning.Load().(*tuning)
+}
+
+// updateTuning applies @update to a copy of the current tuning and publishes it, unless
+// @update fails. Matrices in the copy are shared with the old snapshot, so @update must
+// replace rather than modify them.
+func (d *FrequencySensor) updateTuning(update func(*tuning) error) error {
+	d.tuneLock.Lock()
+	defer d.tuneLock.Unlock()
+	return d.updateTuningLocked(update)
+}
+
+// updateTuningLocked is updateTuning for callers that hold tuneLock.
+func (d *FrequencySensor) updateTuningLocked(update func(*tuning) error) error {
+	t := *d.loadTuning()
+	if err := update(&t); err != nil {
+		return err
+	}
+	d.tuning.Store(&t)
+	return nil
+}
+
+// SetScheduler makes the sensor count beats for @s and publish it on the graphql API.
+func (d *FrequencySensor) SetScheduler(s *Scheduler) {
+	d.tuneLock.Lock()
+	defer d.tuneLock.Unlock()
+	d.scheduler = s
+}
+
+// Scheduler returns the scheduler set with SetScheduler, or nil.
+func (d *FrequencySensor) Scheduler() *Scheduler {
+	d.tuneLock.Lock()
+	defer d.tuneLock.Unlock()
+	return d.scheduler
+}
+
+// beat passes a detected beat on to the modulators, as the event "beat", and the scheduler.
+func (d *FrequencySensor) beat() {
+	d.modulators.Trigger("beat")
+	if s := d.Scheduler(); s != nil {
+		s.Beat()
+	}
+}
+
+// Slewer returns the slewer that smooths changes to the parameters.
+func (d *FrequencySensor) Slewer() *Slewer {
+	return d.slewer
+}
+
+// Modulators returns the modulators that are applied to the parameters every frame.
+func (d *FrequencySensor) Modulators() *Modulators {
+	return d.modulators
+}
+
+// FrameParameters returns the parameters used for the current frame, which include the
+// modulations. Renderers should use these rather than the ones in the store.
+func (d *FrequencySensor) FrameParameters() *Parameters {
+	return d.frameParams.Load().(*Parameters)
+}
+
+// loadSnapshots takes the snapshots of the parameters and tuning for the next frame, and
+// applies the slew and the modulators. They advance by one frame at the frame rate rather
+// than by the time since the last frame, which depends on how the input is buffered.
+func (d *FrequencySensor) loadSnapshots() {
+	d.lastFrame = time.Now()
+
+	// writers that change both hold tuneLock, so the frame sees both changes or neither
+	d.tuneLock.Lock()
+	stored, version := d.paramStore.LoadVersion()
+	t := d.loadTuning()
+	d.tuneLock.Unlock()
+
+	d.ParamVersion = version
+	d.params = d.stepParameters(stored, version, t)
+	if t != d.lastTuning {
+		if levels(t.filter.gain) != levels(d.filterValues.gain) ||
+			levels(t.filter.diff) != levels(d.filterValues.diff) {
+			// the filter chain changed shape, so its state starts over
+			d.filterValues = newFilterValues(t.filter, d.Buckets)
+		}
+		d.filterParams = t.filter
+		d.vgc.configure(t.vgc)
+		d.lastTuning = t
+	}
+	d.updateTimes(t)
+}
+
+// StepParameters advances the slew and the modulators by a frame and returns the
+// parameters of the frame, which FrameParameters returns until the next step. Process steps
+// them for each of its frames; frames that come from elsewhere, such as a Replayer, need a
+// call for each one instead. It must not be called while Process runs.
+func (d *FrequencySensor) StepParameters() *Parameters {
+	d.tuneLock.Lock()
+	stored, version := d.paramStore.LoadVersion()
+	t := d.loadTuning()
+	d.tuneLock.Unlock()
+	return d.stepParameters(stored, version, t)
+}
+
+func (d *FrequencySensor) stepParameters(stored *Parameters, version uint64, t *tuning) *Parameters {
+	dt := 1 / t.frameRate
+	params := d.modulators.Apply(d.slewer.Step(stored, version, dt), dt)
+	d.frameParams.Store(params)
+	return params
+}
+
+// stamp fills in the timing of the frame.
+func (d *FrequencySensor) stamp() {
+	d.Seq = uint64(d.frameCount)
+	d.Time = d.lastFrame
+	if d.SampleRate > 0 {
+		d.samples += d.SampleRate / d.lastTuning.frameRate
+	}
+	d.SamplePosition = int64(math.Floor(d.samples + 0.5))
+}
+
 // tao is a value  >=1 which determines the time constant of the filter. A value of 1 means
 // no lowpass, where a large value means a long time delay.
 func (d *FrequencySensor) SetFilterParams(typ string, level int, gain, tao float64) error {
@@ -161,22 +467,59 @@ func (d *FrequencySensor) SetFilterParams(typ string, level int, gain, tao float
 	}
 	params := []float64{a, b}
 
-	var m *mat.Dense
+	return d.updateTuning(func(t *tuning) error {
+		var m *mat.Dense
+		switch typ {
+		case "amp":
+			m = mat.DenseCopyOf(t.filter.gain)
+			t.filter.gain = m
+		case "diff":
+			m = mat.DenseCopyOf(t.filter.diff)
+			t.filter.diff = m
+		default:
+			return errors.New("typ must be either 'amp' or 'diff'")
+		}
+		if n := levels(m); level < 0 || level >= n {
+			return fmt.Errorf("level %d not defined for %s filter with %d levels", level, typ, n)
+		}
+
+		m.SetRow(level, params)
+		return nil
+	})
+}
+
+// SetRawFilter replaces the "amp" or "diff" filter chain with @raw, which holds the two
+// coefficients of each level in order. The chain can have any number of levels; if that
+// changes, the state of the filter starts over.
+func (d *FrequencySensor) SetRawFilter(typ string, raw []float64) error {
+	m, err := newFilterChain(raw)
+	if err != nil {
+		return err
+	}
+	return d.updateTuning(func(t *tuning) error {
+		switch typ {
+		case "amp":
+			t.filter.gain = m
+		case "diff":
+			t.filter.diff = m
+		default:
+			return errors.New("typ must be either 'amp' or 'diff'")
+		}
+		return nil
+	})
+}
+
+// FilterParams returns the coefficients of the "amp" or "diff" filter chain, two for each
+// level.
+func (d *FrequencySensor) FilterParams(typ string) ([]float64, error) {
+	t := d.loadTuning()
 	switch typ {
 	case "amp":
-		m = d.filterParams.gain
+		return copyFloats(t.filter.gain.RawMatrix().Data), nil
 	case "diff":
-		m = d.filterParams.diff
-	default:
-		return errors.New("typ must be either 'amp' or 'diff'")
-	}
-	rows, _ := m.Dims()
-	if level >= rows {
-		return errors.New("level not defined for filter typ")
+		return copyFloats(t.filter.diff.RawMatrix().Data), nil
 	}
-
-	m.SetRow(level, params)
-	return nil
+	return nil, errors.New("typ must be either 'amp' or 'diff'")
 }
 
 func (d *FrequencySensor) applyFilters(frame []float64) {
@@ -187,22 +530,41 @@ func (d *FrequencySensor) applyFilters(frame []float64) {
 
 	d.adjustVariableGain(frame)
 
-	var diffInput = mat.NewDense(2, d.Buckets, nil)
-	d.applyFilter(frame, d.filterValues.gain, d.filterParams.gain, diffInput)
-	d.applyFilter(diffInput.RawRowView(0), d.filterValues.diff, d.filterParams.diff, nil)
+	var diffInput = mat.NewDense(levels(d.filterParams.gain), d.Buckets, nil)
+	var times *bucketTimes
+	if d.times != nil && d.times.active {
+		times = d.times
+	}
+	d.applyFilter(frame, d.filterValues.gain, d.filterParams.gain, diffInput, times)
+	d.applyFilter(diffInput.RawRowView(0), d.filterValues.diff, d.filterParams.diff, nil, nil)
 }
 
-func (d *FrequencySensor) applyFilter(frame []float64, output, fp, di *mat.Dense) {
-	for level := 0; level < 2; level++ {
-		// m looks like:
-		// [ frame0, ..., frameN ]
-		// [ out0,   ..., outN   ]
-		m := mat.NewDense(2, d.Buckets, append(frame, output.RawRowView(level)...))
-		at := fp.RowView(level)
-
-		// perform the fitler operation using out.T = [frame[:], output[:]] * params.T
+// applyFilter runs @frame through each level of the chain @fp in turn. Each level is a one
+// pole lowpass whose output is kept in the matching row of @output. If @times isn't nil,
+// the first level uses its coefficients for each bucket instead of the ones in @fp.
+func (d *FrequencySensor) applyFilter(frame []float64, output, fp, di *mat.Dense, times *bucketTimes) {
+	n := levels(fp)
+	for level := 0; level < n; level++ {
 		var out = mat.NewVecDense(d.Buckets, nil)
-		out.MulVec(m.T(), at)
+		if level == 0 && times != nil {
+			prev := output.RawRowView(0)
+			for i, x := range frame {
+				c := times.release
+				if x > prev[i] {
+					c = times.attack
+				}
+				out.SetVec(i, c[0][i]*x+c[1][i]*prev[i])
+			}
+		} else {
+			// m looks like:
+			// [ frame0, ..., frameN ]
+			// [ out0,   ..., outN   ]
+			m := mat.NewDense(2, d.Buckets, append(frame, output.RawRowView(level)...))
+			at := fp.RowView(level)
+
+			// perform the fitler operation using out.T = [frame[:], output[:]] * params.T
+			out.MulVec(m.T(), at)
+		}
 
 		if di != nil {
 			// get the differential since the last output
@@ -216,18 +578,24 @@ func (d *FrequencySensor) applyFilter(frame []float64, output, fp, di *mat.Dense
 		output.SetRow(level, frame)
 	}
 
-	// apply output of the second filter as feedback
+	// apply output of the later filters as feedback
+	if n < 2 {
+		return
+	}
 	var s = mat.NewVecDense(d.Buckets, nil)
-	s.AddVec(output.RowView(0), output.RowView(1))
+	for level := 0; level < n; level++ {
+		s.AddVec(s, output.RowView(level))
+	}
 	y := s.RawVector().Data
 	output.SetRow(0, y)
 }
 
-// The VGA works by taking the sigmoid function of the difference of the current
-// long-term gain value with 1. This value is then applied as input to a low-pass
+// The VGA works by taking the error curve (see VGCPreset.Curve) of the difference of the
+// current long-term gain value with the target. This value is then applied as input to a low-pass
 // filter whose output will be the gain of the 1st level filter for the next incoming frame.
 func (d *FrequencySensor) adjustVariableGain(frame []float64) {
 	d.vgc.apply(frame)
+	d.gainHistory.push(d.frameCount, d.vgc.gain)
 	if d.params.Debug && d.frameCount%200 == 0 {
 		bs, _ := json.Marshal(map[string]interface{}{"vgc.gain": d.vgc.gain})
 		fmt.Println(string(bs))
@@ -243,7 +611,7 @@ func (d *FrequencySensor) applyChannelEffects() {
 	diff := d.filterValues.diff.RawRowView(0)
 
 	if d.params.Mode == AnimateMode {
-		decay := 1 - (2.0 / float64(d.Frames))
+		decay := d.decay()
 		for i := len(d.Amplitude) / 2; i >= 0; i-- { // -2
 			for j := range d.Amplitude[i] {
 				d.Amplitude[i+1][j] = decay * d.Amplitude[i][j]
@@ -310,7 +678,8 @@ func (d *FrequencySensor) applyBase(frame []float64) {
 	// if d.params.Debug && d.frameCount%10 == 0 {
 	// 	fmt.Println("@@@ BASE", bass)
 	// }
-	d.Bass = .75*bass + .25*d.Bass
+	a := d.bassSmoothing()
+	d.Bass = a*bass + (1-a)*d.Bass
 }
 
 func (d *FrequencySensor) applyPreemphasis(frame []float64) {
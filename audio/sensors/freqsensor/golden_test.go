@@ -0,0 +1,264 @@
+package freqsensor
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenFrames is how many frames of each input are compared.
+const goldenFrames = 32
+
+// goldenTolerance is how far, relative to its size, a value can be from the golden one.
+const goldenTolerance = 1e-6
+
+// goldenFrame is the output of a frame as it's kept in a golden file.
+type goldenFrame struct {
+	Amplitude [][]float64        `json:"amplitude"`
+	Diff      []float64          `json:"diff"`
+	Energy    []float64          `json:"energy"`
+	Bass      float64            `json:"bass"`
+	Bands     map[string]float64 `json:"bands"`
+}
+
+// rounded returns a copy of the frame with each value rounded to @digits significant digits.
+func (f goldenFrame) rounded(digits int) goldenFrame {
+	round := func(v float64) float64 {
+		r, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', digits, 64), 64)
+		return r
+	}
+	floats := func(values []float64) []float64 {
+		out := make([]float64, len(values))
+		for i, v := range values {
+			out[i] = round(v)
+		}
+		return out
+	}
+	r := goldenFrame{
+		Diff:   floats(f.Diff),
+		Energy: floats(f.Energy),
+		Bass:   round(f.Bass),
+		Bands:  make(map[string]float64, len(f.Bands)),
+	}
+	for _, row := range f.Amplitude {
+		r.Amplitude = append(r.Amplitude, floats(row))
+	}
+	for name, v := range f.Bands {
+		r.Bands[name] = round(v)
+	}
+	return r
+}
+
+// syntheticInputs are inputs of 16 buckets that are fed to ProcessBuckets.
+var syntheticInputs = map[string]func(frame, bucket int) float64{
+	"silence": func(frame, bucket int) float64 { return 0 },
+	"step": func(frame, bucket int) float64 {
+		if frame >= 4 && frame < 20 {
+			return 1
+		}
+		return 0
+	},
+	"impulses": func(frame, bucket int) float64 {
+		if frame%8 == 0 {
+			return 2
+		}
+		return 0
+	},
+	// a peak that moves up through the buckets
+	"sweep": func(frame, bucket int) float64 {
+		d := float64(bucket) - float64(frame)/2
+		return math.Exp(-d * d / 2)
+	},
+}
+
+func TestGolden(t *testing.T) {
+	for name, f := range syntheticInputs {
+		t.Run(name, func(t *testing.T) {
+			frames := make([][]float64, goldenFrames)
+			for i := range frames {
+				frames[i] = make([]float64, 16)
+				for j := range frames[i] {
+					frames[i][j] = f(i, j)
+				}
+			}
+			checkGolden(t, name, runGolden(t, frames, false))
+		})
+	}
+
+	// seeded, so that it's the same every run
+	t.Run("noise", func(t *testing.T) {
+		rnd := rand.New(rand.NewSource(1))
+		frames := make([][]float64, goldenFrames)
+		for i := range frames {
+			frames[i] = make([]float64, 16)
+			for j := range frames[i] {
+				frames[i][j] = rnd.Float64()
+			}
+		}
+		checkGolden(t, "noise", runGolden(t, frames, false))
+	})
+
+	// recorded spectra go through the bucketer too
+	inputs, err := filepath.Glob(filepath.Join("testdata", "inputs", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range inputs {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			bs, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var frames [][]float64
+			if err := json.Unmarshal(bs, &frames); err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) > goldenFrames {
+				frames = frames[:goldenFrames]
+			}
+			checkGolden(t, name, runGolden(t, frames, true))
+		})
+	}
+}
+
+// runGolden feeds @frames through a sensor with the default parameters, either as spectra
+// or as buckets, and returns what it outputs.
+func runGolden(t *testing.T, frames [][]float64, spectra bool) []goldenFrame {
+	d := newTestSensor(t, nil)
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64, 1)
+	defer close(in)
+
+	var out chan *Drivers
+	if spectra {
+		// Process takes the first spectrum to size the bucketer
+		in <- frames[0]
+		out = d.Process(done, in)
+	} else {
+		out = d.ProcessBuckets(done, in)
+	}
+	var res []goldenFrame
+	for _, frame := range frames {
+		in <- frame
+		drv := <-out
+		res = append(res, goldenFrame{
+			Amplitude: drv.Amplitude,
+			Diff:      drv.Diff,
+			Energy:    drv.Energy,
+			Bass:      drv.Bass,
+			Bands:     drv.Bands,
+		})
+	}
+	return res
+}
+
+// checkGolden compares @got to testdata/golden/@name.json, or rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []goldenFrame) {
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *update {
+		// a frame on each line, with enough digits for the tolerance
+		var lines []string
+		for _, f := range got {
+			bs, err := json.Marshal(f.rounded(9))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, "\t"+string(bs))
+		}
+		bs := []byte("[\n" + strings.Join(lines, ",\n") + "\n]")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, append(bs, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run the tests with -update to create it", err)
+	}
+	var want []goldenFrame
+	if err := json.Unmarshal(bs, &want); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, the golden file has %d", len(got), len(want))
+	}
+	var diffs []string
+	for i := range want {
+		diffs = append(diffs, diffFrames(fmt.Sprintf("frame %d", i), got[i], want[i])...)
+	}
+	if len(diffs) > 10 {
+		diffs = append(diffs[:10], fmt.Sprintf("and %d more", len(diffs)-10))
+	}
+	if len(diffs) > 0 {
+		t.Errorf("output differs from %s; if that's intended, run the tests with -update\n%s",
+			path, strings.Join(diffs, "\n"))
+	}
+}
+
+func diffFrames(at string, got, want goldenFrame) []string {
+	var diffs []string
+	floats := func(field string, got, want []float64) {
+		if len(got) != len(want) {
+			diffs = append(diffs, fmt.Sprintf("%s %s: got %d values, want %d",
+				at, field, len(got), len(want)))
+			return
+		}
+		for i := range want {
+			if !closeTo(got[i], want[i]) {
+				diffs = append(diffs, fmt.Sprintf("%s %s[%d]: got %v, want %v",
+					at, field, i, got[i], want[i]))
+			}
+		}
+	}
+	if len(got.Amplitude) != len(want.Amplitude) {
+		diffs = append(diffs, fmt.Sprintf("%s amplitude: got %d rows, want %d",
+			at, len(got.Amplitude), len(want.Amplitude)))
+	} else {
+		for i := range want.Amplitude {
+			floats(fmt.Sprintf("amplitude[%d]", i), got.Amplitude[i], want.Amplitude[i])
+		}
+	}
+	floats("diff", got.Diff, want.Diff)
+	floats("energy", got.Energy, want.Energy)
+	floats("bass", []float64{got.Bass}, []float64{want.Bass})
+
+	var names []string
+	for name := range want.Bands {
+		names = append(names, name)
+	}
+	for name := range got.Bands {
+		if _, ok := want.Bands[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g, gok := got.Bands[name]
+		w, wok := want.Bands[name]
+		if gok != wok || !closeTo(g, w) {
+			diffs = append(diffs, fmt.Sprintf("%s band %s: got %v, want %v", at, name, g, w))
+		}
+	}
+	return diffs
+}
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= goldenTolerance*math.Max(1, math.Abs(want))
+}
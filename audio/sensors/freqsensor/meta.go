@@ -0,0 +1,234 @@
+package freqsensor
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// ParamInfo describes a parameter field, as declared by these struct tags:
+//
+//	min, max  the range of the value
+//	step      the resolution a UI should use
+//	default   the value a UI should reset to
+//	unit      the unit of the value, e.g. "dB"
+//	desc      what the parameter does
+//	enum      the allowed values of an int field as name=value pairs, e.g. "normal=0,animate=1"
+type ParamInfo struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Min         *float64    `json:"min"`
+	Max         *float64    `json:"max"`
+	Step        *float64    `json:"step"`
+	Default     string      `json:"default"`
+	Unit        string      `json:"unit"`
+	Description string      `json:"description"`
+	Enum        []EnumValue `json:"enum"`
+
+	field int
+	kind  reflect.Kind
+}
+
+// EnumValue is one of the allowed values of an enumerated parameter.
+type EnumValue struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+}
+
+// ParamInfos returns the metadata of the fields of Parameters that are published on the
+// graphql API.
+func ParamInfos() []*ParamInfo {
+	infos, err := paramInfos(reflect.TypeOf(Parameters{}))
+	if err != nil {
+		panic(err)
+	}
+	return infos
+}
+
+// paramInfos reads the metadata of the fields of @ref that have a json tag, in order.
+func paramInfos(ref reflect.Type) ([]*ParamInfo, error) {
+	var infos []*ParamInfo
+	for i := 0; i < ref.NumField(); i++ {
+		f := ref.Field(i)
+		tag := jsonTag(&f)
+		if tag == "" || tag == "-" {
+			continue
+		}
+		info := &ParamInfo{
+			Name:        tag,
+			Default:     f.Tag.Get("default"),
+			Unit:        f.Tag.Get("unit"),
+			Description: f.Tag.Get("desc"),
+			field:       i,
+			kind:        f.Type.Kind(),
+		}
+
+		switch info.kind {
+		case reflect.Bool:
+			info.Type = "Boolean"
+		case reflect.Float32, reflect.Float64:
+			info.Type = "Float"
+		case reflect.String:
+			info.Type = "String"
+		case reflect.Int, reflect.Int8, reflect.Int32, reflect.Int64:
+			info.Type = "Int"
+		default:
+			return nil, fmt.Errorf("%s: unsupported type %v", tag, f.Type)
+		}
+
+		for key, dst := range map[string]**float64{"min": &info.Min, "max": &info.Max, "step": &info.Step} {
+			s := f.Tag.Get(key)
+			if s == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad %s tag: %v", tag, key, err)
+			}
+			*dst = &v
+		}
+
+		if enum := f.Tag.Get("enum"); enum != "" {
+			if info.Type != "Int" {
+				return nil, fmt.Errorf("%s: enum is only supported on int fields", tag)
+			}
+			for _, pair := range strings.Split(enum, ",") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("%s: bad enum value %q", tag, pair)
+				}
+				v, err := strconv.Atoi(kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("%s: bad enum value %q", tag, pair)
+				}
+				info.Enum = append(info.Enum, EnumValue{Name: kv[0], Value: v})
+			}
+		}
+
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// describe summarizes the metadata for the description of a graphql field.
+func (pi *ParamInfo) describe() string {
+	s := pi.Description
+	if pi.Min != nil && pi.Max != nil {
+		s += fmt.Sprintf(" [%v, %v]", *pi.Min, *pi.Max)
+	}
+	if pi.Unit != "" {
+		s += " (" + pi.Unit + ")"
+	}
+	for i, e := range pi.Enum {
+		if i == 0 {
+			s += " one of:"
+		}
+		s += fmt.Sprintf(" %d=%s", e.Value, e.Name)
+	}
+	return strings.TrimSpace(s)
+}
+
+// Validate returns an error if @val isn't allowed for the parameter.
+func (pi *ParamInfo) Validate(val interface{}) error {
+	var v float64
+	switch x := val.(type) {
+	case float64:
+		v = x
+	case int:
+		v = float64(x)
+	default:
+		return nil
+	}
+
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%s: %v is not a number", pi.Name, v)
+	}
+	if len(pi.Enum) > 0 {
+		for _, e := range pi.Enum {
+			if float64(e.Value) == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %v is not one of %s", pi.Name, v, pi.describe())
+	}
+	if pi.Min != nil && v < *pi.Min {
+		return fmt.Errorf("%s: %v is below the minimum of %v", pi.Name, v, *pi.Min)
+	}
+	if pi.Max != nil && v > *pi.Max {
+		return fmt.Errorf("%s: %v is above the maximum of %v", pi.Name, v, *pi.Max)
+	}
+	return nil
+}
+
+// parse converts @s to the type of the parameter.
+func (pi *ParamInfo) parse(s string) (interface{}, error) {
+	switch pi.Type {
+	case "Boolean":
+		return strconv.ParseBool(s)
+	case "Float":
+		return strconv.ParseFloat(s, 64)
+	case "Int":
+		return strconv.Atoi(s)
+	}
+	return s, nil
+}
+
+// tagDefaults returns Parameters with every published field set to its default tag.
+func tagDefaults() *Parameters {
+	p := new(Parameters)
+	elem := reflect.ValueOf(p).Elem()
+	for _, pi := range ParamInfos() {
+		if pi.Default == "" {
+			continue
+		}
+		v, err := pi.parse(pi.Default)
+		if err != nil {
+			panic(fmt.Errorf("%s: bad default tag: %v", pi.Name, err))
+		}
+		field := elem.Field(pi.field)
+		field.Set(reflect.ValueOf(v).Convert(field.Type()))
+	}
+	return p
+}
+
+// ValidateParameters checks every published field of @p against its metadata.
+func ValidateParameters(p *Parameters) error {
+	elem := reflect.ValueOf(p).Elem()
+	for _, pi := range ParamInfos() {
+		if err := pi.Validate(elem.Field(pi.field).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var paramInfoType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "ParamInfo",
+		Fields: graphql.Fields{
+			"name":        &graphql.Field{Type: graphql.String},
+			"type":        &graphql.Field{Type: graphql.String},
+			"min":         &graphql.Field{Type: graphql.Float},
+			"max":         &graphql.Field{Type: graphql.Float},
+			"step":        &graphql.Field{Type: graphql.Float},
+			"default":     &graphql.Field{Type: graphql.String},
+			"unit":        &graphql.Field{Type: graphql.String},
+			"description": &graphql.Field{Type: graphql.String},
+			"enum": &graphql.Field{
+				Type: graphql.NewList(graphql.NewObject(
+					graphql.ObjectConfig{
+						Name: "EnumValue",
+						Fields: graphql.Fields{
+							"name":  &graphql.Field{Type: graphql.String},
+							"value": &graphql.Field{Type: graphql.Int},
+						},
+					},
+				)),
+			},
+		},
+	},
+)
@@ -0,0 +1,67 @@
+package freqsensor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParamInfo(t *testing.T) {
+	if err := ValidateParameters(DefaultParameters); err != nil {
+		t.Fatal("expected the defaults to be valid:", err)
+	}
+	for _, pi := range ParamInfos() {
+		if pi.Default == "" {
+			t.Errorf("%s has no default", pi.Name)
+		}
+	}
+	if p := DefaultParameters; p.GlobalBrightness != 127 || p.DifferentialGain != 0.002 ||
+		p.Mode != AnimateMode || p.BassSmoothing != 30 || p.Debug {
+		t.Errorf("expected the defaults from the tags, got %+v", p)
+	}
+
+	d := newTestSensor(t, nil)
+	for _, bad := range []string{
+		`mutation { params(params: {period: 0}) { period } }`,
+		`mutation { params(params: {mode: 7}) { mode } }`,
+		`mutation { params(params: {gain: 1, gbr: 300}) { gbr } }`,
+	} {
+		res := d.Query(bad, nil)
+		if len(res.Errors) == 0 {
+			t.Error("expected an error from", bad)
+		}
+	}
+	if p := d.Parameters().Load(); p.Gain != DefaultParameters.Gain {
+		t.Error("expected a rejected mutation to change nothing, got gain", p.Gain)
+	}
+
+	res := d.Query(`{ paramInfo { name type min max step default unit description enum { name value } } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	bs, _ := json.Marshal(res.Data)
+	var data struct {
+		ParamInfo []ParamInfo `json:"paramInfo"`
+	}
+	if err := json.Unmarshal(bs, &data); err != nil {
+		t.Fatal(err)
+	}
+	infos := make(map[string]ParamInfo)
+	for _, pi := range data.ParamInfo {
+		infos[pi.Name] = pi
+	}
+	if pi := infos["period"]; pi.Min == nil || *pi.Min != 1 || pi.Default != "24" || pi.Unit != "columns" {
+		t.Errorf("unexpected period info %+v", pi)
+	}
+	if pi := infos["mode"]; len(pi.Enum) != 2 || pi.Enum[1].Name != "animate" || pi.Enum[1].Value != 1 {
+		t.Errorf("unexpected mode info %+v", pi)
+	}
+
+	res = d.Query(`{ __type(name: "ParamType") { fields { name description } } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	if bs, _ := json.Marshal(res.Data); !strings.Contains(string(bs), "[1, 256] (columns)") {
+		t.Error("expected the range to be in the field description:", string(bs))
+	}
+}
@@ -0,0 +1,418 @@
+package freqsensor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+)
+
+// Modulation sources
+const (
+	LFOSource      = "lfo"
+	EnvelopeSource = "envelope"
+	FeatureSource  = "feature"
+)
+
+// LFO waveforms
+const (
+	SineWave     = "sine"
+	TriangleWave = "triangle"
+	SawWave      = "saw"
+	RandomWave   = "random"
+)
+
+// Modulation drives a parameter from an LFO, an envelope or an audio feature. Every source
+// produces a value in [0,1], which is smoothed and then mapped onto [Min, Max] of the target.
+type Modulation struct {
+	Name string `json:"name" yaml:"name"`
+	// Target is the json name of the Parameters field to modulate.
+	Target string `json:"target" yaml:"target"`
+	// Source is one of "lfo", "envelope" or "feature".
+	Source string `json:"source" yaml:"source"`
+	// Add adds the mapped value to the parameter instead of replacing it.
+	Add bool    `json:"add" yaml:"add"`
+	Min float64 `json:"min" yaml:"min"`
+	Max float64 `json:"max" yaml:"max"`
+	// Smoothing is the time constant of a lowpass on the source in seconds.
+	Smoothing float64 `json:"smoothing" yaml:"smoothing"`
+
+	// Wave is the waveform of an LFO: "sine", "triangle", "saw" or "random", which holds
+	// a new random value for each cycle.
+	Wave string `json:"wave" yaml:"wave"`
+	// Rate is the frequency of an LFO in Hz.
+	Rate float64 `json:"rate" yaml:"rate"`
+	// Phase is the starting phase of an LFO in cycles.
+	Phase float64 `json:"phase" yaml:"phase"`
+
+	// Event is the name of the event that triggers an envelope.
+	Event string `json:"event" yaml:"event"`
+	// Attack, Decay, Hold and Release are the stages of an envelope in seconds. The
+	// envelope stays at Sustain for Hold seconds after each trigger.
+	Attack  float64 `json:"attack" yaml:"attack"`
+	Decay   float64 `json:"decay" yaml:"decay"`
+	Sustain float64 `json:"sustain" yaml:"sustain"`
+	Hold    float64 `json:"hold" yaml:"hold"`
+	Release float64 `json:"release" yaml:"release"`
+
+	// Feature is the name of the audio feature a binding follows, e.g. "bass" or "band:air".
+	Feature string `json:"feature" yaml:"feature"`
+	// InMin and InMax are the range of the feature that maps to [0,1].
+	InMin float64 `json:"inMin" yaml:"inMin"`
+	InMax float64 `json:"inMax" yaml:"inMax"`
+}
+
+// Validate checks that the modulation is complete and targets a numeric parameter.
+func (m *Modulation) Validate() error {
+	if m.Name == "" {
+		return errors.New("modulation has no name")
+	}
+	var target *ParamInfo
+	for _, pi := range ParamInfos() {
+		if pi.Name == m.Target {
+			target = pi
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("modulation %s: unknown target %q", m.Name, m.Target)
+	}
+	if (target.Type != "Float" && target.Type != "Int") || len(target.Enum) > 0 {
+		return fmt.Errorf("modulation %s: target %s is not continuous", m.Name, m.Target)
+	}
+	if m.Smoothing < 0 {
+		return fmt.Errorf("modulation %s: smoothing must not be negative", m.Name)
+	}
+
+	switch m.Source {
+	case LFOSource:
+		switch m.Wave {
+		case SineWave, TriangleWave, SawWave, RandomWave:
+		default:
+			return fmt.Errorf("modulation %s: unknown wave %q", m.Name, m.Wave)
+		}
+		if m.Rate <= 0 {
+			return fmt.Errorf("modulation %s: rate must be positive", m.Name)
+		}
+	case EnvelopeSource:
+		if m.Event == "" {
+			return fmt.Errorf("modulation %s: envelope needs an event", m.Name)
+		}
+		if m.Attack < 0 || m.Decay < 0 || m.Hold < 0 || m.Release < 0 {
+			return fmt.Errorf("modulation %s: envelope times must not be negative", m.Name)
+		}
+		if m.Sustain < 0 || m.Sustain > 1 {
+			return fmt.Errorf("modulation %s: sustain must be in [0,1]", m.Name)
+		}
+	case FeatureSource:
+		if m.Feature == "" {
+			return fmt.Errorf("modulation %s: binding needs a feature", m.Name)
+		}
+		if m.InMax == m.InMin {
+			return fmt.Errorf("modulation %s: feature range is empty", m.Name)
+		}
+	default:
+		return fmt.Errorf("modulation %s: unknown source %q", m.Name, m.Source)
+	}
+	return nil
+}
+
+// envelope stages
+const (
+	envIdle = iota
+	envAttack
+	envDecay
+	envHold
+	envRelease
+)
+
+// modulator is the running state of a Modulation.
+type modulator struct {
+	Modulation
+	target *ParamInfo
+
+	phase  float64
+	random float64
+	stage  int
+	level  float64
+	held   float64
+	value  float64
+	primed bool
+}
+
+func newModulator(m Modulation) *modulator {
+	mod := &modulator{Modulation: m, phase: m.Phase, random: rand.Float64()}
+	for _, pi := range ParamInfos() {
+		if pi.Name == m.Target {
+			mod.target = pi
+		}
+	}
+	return mod
+}
+
+func (m *modulator) trigger() {
+	// retriggering attacks from the current level so there's no jump
+	m.stage = envAttack
+	m.held = 0
+}
+
+// step advances the modulator by @dt seconds and returns its smoothed value in [0,1].
+func (m *modulator) step(dt float64, features map[string]float64) float64 {
+	var x float64
+	switch m.Source {
+	case LFOSource:
+		x = m.lfo(dt)
+	case EnvelopeSource:
+		x = m.envelope(dt)
+	case FeatureSource:
+		x = (features[m.Feature] - m.InMin) / (m.InMax - m.InMin)
+		x = math.Max(0, math.Min(1, x))
+	}
+
+	if !m.primed || m.Smoothing == 0 {
+		m.value = x
+		m.primed = true
+	} else {
+		a := 1 - math.Exp(-dt/m.Smoothing)
+		m.value += a * (x - m.value)
+	}
+	return m.value
+}
+
+func (m *modulator) lfo(dt float64) float64 {
+	m.phase += m.Rate * dt
+	if m.phase >= 1 {
+		m.phase -= math.Floor(m.phase)
+		m.random = rand.Float64()
+	}
+	switch m.Wave {
+	case SineWave:
+		return 0.5 + 0.5*math.Sin(2*math.Pi*m.phase)
+	case TriangleWave:
+		return 1 - math.Abs(2*m.phase-1)
+	case SawWave:
+		return m.phase
+	case RandomWave:
+		return m.random
+	}
+	return 0
+}
+
+// ramp moves the level toward @to at a rate that covers a full swing in @seconds, and
+// tells whether it got there.
+func (m *modulator) ramp(to, seconds, dt float64) bool {
+	if seconds <= 0 {
+		m.level = to
+		return true
+	}
+	d := dt / seconds
+	if math.Abs(to-m.level) <= d {
+		m.level = to
+		return true
+	}
+	if to > m.level {
+		m.level += d
+	} else {
+		m.level -= d
+	}
+	return false
+}
+
+func (m *modulator) envelope(dt float64) float64 {
+	switch m.stage {
+	case envAttack:
+		if m.ramp(1, m.Attack, dt) {
+			m.stage = envDecay
+		}
+	case envDecay:
+		if m.ramp(m.Sustain, m.Decay, dt) {
+			m.stage = envHold
+		}
+	case envHold:
+		m.held += dt
+		if m.held >= m.Hold {
+			m.stage = envRelease
+		}
+	case envRelease:
+		if m.ramp(0, m.Release, dt) {
+			m.stage = envIdle
+		}
+	}
+	return m.level
+}
+
+// Modulators applies a set of modulations to parameter snapshots.
+type Modulators struct {
+	lock     sync.Mutex
+	mods     map[string]*modulator
+	features map[string]float64
+}
+
+// NewModulators creates a set of modulators from @mods.
+func NewModulators(mods []Modulation) (*Modulators, error) {
+	m := &Modulators{
+		mods:     make(map[string]*modulator),
+		features: make(map[string]float64),
+	}
+	for _, mod := range mods {
+		if err := m.Set(mod); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Set adds a modulation, or replaces the one with the same name.
+func (m *Modulators) Set(mod Modulation) error {
+	if err := mod.Validate(); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.mods[mod.Name] = newModulator(mod)
+	return nil
+}
+
+// Remove removes the modulation called @name and tells whether it existed.
+func (m *Modulators) Remove(name string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, ok := m.mods[name]
+	delete(m.mods, name)
+	return ok
+}
+
+// ModulationState is a modulation with its current value.
+type ModulationState struct {
+	Modulation
+	Value float64 `json:"value"`
+}
+
+// States returns the modulations and their current values in order of name.
+func (m *Modulators) States() []ModulationState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	states := make([]ModulationState, 0, len(m.mods))
+	for _, mod := range m.sorted() {
+		states = append(states, ModulationState{mod.Modulation, mod.value})
+	}
+	return states
+}
+
+func (m *Modulators) sorted() []*modulator {
+	mods := make([]*modulator, 0, len(m.mods))
+	for _, mod := range m.mods {
+		mods = append(mods, mod)
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Name < mods[j].Name })
+	return mods
+}
+
+// SetFeature updates an audio feature that bindings can follow.
+func (m *Modulators) SetFeature(name string, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.features[name] = value
+}
+
+// Trigger starts the envelopes that are triggered by @event.
+func (m *Modulators) Trigger(event string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, mod := range m.mods {
+		if mod.Source == EnvelopeSource && mod.Event == event {
+			mod.trigger()
+		}
+	}
+}
+
+// Apply advances the modulators by @dt seconds and returns a copy of @p with the
+// modulations applied, clamped to the ranges of the parameters. @p itself isn't changed.
+func (m *Modulators) Apply(p *Parameters, dt float64) *Parameters {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.mods) == 0 {
+		return p
+	}
+
+	params := *p
+	elem := reflect.ValueOf(&params).Elem()
+	for _, mod := range m.sorted() {
+		v := mod.step(dt, m.features)
+		v = mod.Min + v*(mod.Max-mod.Min)
+
+		field := elem.Field(mod.target.field)
+		if mod.Add {
+			if field.Kind() == reflect.Float64 {
+				v += field.Float()
+			} else {
+				v += float64(field.Int())
+			}
+		}
+		if mod.target.Min != nil {
+			v = math.Max(v, *mod.target.Min)
+		}
+		if mod.target.Max != nil {
+			v = math.Min(v, *mod.target.Max)
+		}
+		if field.Kind() == reflect.Float64 {
+			field.SetFloat(v)
+		} else {
+			field.SetInt(int64(math.Floor(v + 0.5)))
+		}
+	}
+	return &params
+}
+
+// modulationFields returns graphql fields and input fields for the fields of Modulation.
+// The fields resolve from a ModulationState.
+func modulationFields() (graphql.Fields, graphql.InputObjectConfigFieldMap) {
+	fields := graphql.Fields{}
+	inputFields := graphql.InputObjectConfigFieldMap{}
+	ref := reflect.TypeOf(Modulation{})
+	for i := 0; i < ref.NumField(); i++ {
+		f := ref.Field(i)
+		var typ graphql.Output
+		var in graphql.Input
+		switch f.Type.Kind() {
+		case reflect.String:
+			typ, in = graphql.String, graphql.String
+		case reflect.Float64:
+			typ, in = graphql.Float, graphql.Float
+		case reflect.Bool:
+			typ, in = graphql.Boolean, graphql.Boolean
+		}
+		field := i
+		fields[jsonTag(&f)] = &graphql.Field{
+			Type: typ,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				s := p.Source.(ModulationState)
+				return reflect.ValueOf(s.Modulation).Field(field).Interface(), nil
+			},
+		}
+		inputFields[jsonTag(&f)] = &graphql.InputObjectFieldConfig{Type: in}
+	}
+	fields["value"] = &graphql.Field{
+		Type: graphql.Float,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(ModulationState).Value, nil
+		},
+	}
+	return fields, inputFields
+}
+
+// modulationFromArgs decodes a Modulation from the arguments of a mutation.
+func modulationFromArgs(args map[string]interface{}) (Modulation, error) {
+	var m Modulation
+	bs, err := json.Marshal(args)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(bs, &m)
+	return m, err
+}
@@ -0,0 +1,91 @@
+package freqsensor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestModulators(t *testing.T) {
+	m, err := NewModulators([]Modulation{
+		{Name: "wobble", Target: "gain", Source: LFOSource, Wave: TriangleWave, Rate: 1, Min: 1, Max: 3},
+		{Name: "flash", Target: "gbr", Source: EnvelopeSource, Event: "kick",
+			Attack: 0.1, Decay: 0.1, Sustain: 0.5, Hold: 0.2, Release: 0.1, Min: 0, Max: 200, Add: true},
+		{Name: "bass", Target: "period", Source: FeatureSource, Feature: "bass", InMin: 0, InMax: 2,
+			Min: 10, Max: 30},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := *DefaultParameters
+	dt := 0.01
+	var p *Parameters
+	step := func(seconds float64) {
+		for i := 0; i < int(seconds/dt+0.5); i++ {
+			p = m.Apply(&base, dt)
+		}
+	}
+
+	// a quarter cycle into the triangle it's halfway up
+	step(0.25)
+	if math.Abs(p.Gain-2) > 0.05 {
+		t.Error("expected the lfo to be at 2, got", p.Gain)
+	}
+	if p.GlobalBrightness != base.GlobalBrightness {
+		t.Error("expected the envelope to be idle, got", p.GlobalBrightness)
+	}
+	if base.Gain != DefaultParameters.Gain {
+		t.Error("expected the base parameters not to change")
+	}
+
+	m.Trigger("kick")
+	step(0.1)
+	if p.GlobalBrightness < 250 {
+		t.Error("expected the envelope to add to the brightness and clamp at 255, got",
+			p.GlobalBrightness)
+	}
+	step(0.2)
+	if want := base.GlobalBrightness + 100; math.Abs(p.GlobalBrightness-want) > 2 {
+		t.Errorf("expected the envelope to sustain at %v, got %v", want, p.GlobalBrightness)
+	}
+	step(0.3)
+	if p.GlobalBrightness != base.GlobalBrightness {
+		t.Error("expected the envelope to be released, got", p.GlobalBrightness)
+	}
+
+	m.SetFeature("bass", 1)
+	step(dt)
+	if p.Period != 20 {
+		t.Error("expected the bass to map to a period of 20, got", p.Period)
+	}
+
+	if err := m.Set(Modulation{Name: "x", Target: "mode", Source: LFOSource, Wave: SineWave, Rate: 1}); err == nil {
+		t.Error("expected modulating an enum to fail")
+	}
+	if !m.Remove("wobble") || m.Remove("wobble") {
+		t.Error("expected wobble to be removed once")
+	}
+}
+
+func TestModulationGraphql(t *testing.T) {
+	d := newTestSensor(t, nil)
+	res := d.Query(`mutation { setModulation(mod: {name: "w", target: "sync", source: "lfo",
+		wave: "sine", rate: 2, min: 0, max: 0.5}) { name rate value } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	res = d.Query(`mutation { setModulation(mod: {name: "w", target: "sync", source: "lfo", wave: "square", rate: 2}) { name } }`, nil)
+	if len(res.Errors) == 0 {
+		t.Error("expected an unknown wave to fail")
+	}
+	if states := d.Modulators().States(); len(states) != 1 || states[0].Rate != 2 {
+		t.Errorf("unexpected modulations %+v", states)
+	}
+	res = d.Query(`mutation { trigger(event: "kick") removeModulation(name: "w") }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	if len(d.Modulators().States()) != 0 {
+		t.Error("expected the modulation to be removed")
+	}
+}
@@ -0,0 +1,96 @@
+package freqsensor
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ParameterStore holds Parameters as immutable snapshots that are swapped atomically.
+// Readers take a single snapshot with Load and use it for a whole frame, so they never see
+// a half applied change. A snapshot must not be modified; writers go through Update or Set,
+// which publish a new one.
+type ParameterStore struct {
+	current atomic.Value // *versioned
+
+	lock    sync.Mutex // serializes writers and guards subs
+	subs    map[chan *Parameters]struct{}
+	version uint64
+}
+
+// versioned is a snapshot along with its version.
+type versioned struct {
+	params  *Parameters
+	version uint64
+}
+
+// NewParameterStore creates a store whose first snapshot is a copy of @p.
+func NewParameterStore(p *Parameters) *ParameterStore {
+	s := &ParameterStore{subs: make(map[chan *Parameters]struct{})}
+	params := *p
+	s.current.Store(&versioned{params: &params})
+	return s
+}
+
+// Load returns the current snapshot.
+func (s *ParameterStore) Load() *Parameters {
+	return s.current.Load().(*versioned).params
+}
+
+// LoadVersion returns the current snapshot and its version, which counts the snapshots
+// that were published before it.
+func (s *ParameterStore) LoadVersion() (*Parameters, uint64) {
+	v := s.current.Load().(*versioned)
+	return v.params, v.version
+}
+
+// Update applies @update to a copy of the current snapshot and publishes the result.
+func (s *ParameterStore) Update(update func(*Parameters)) *Parameters {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	params := *s.Load()
+	update(&params)
+	s.publish(&params)
+	return &params
+}
+
+// Set publishes a copy of @p as the current snapshot and returns its version.
+func (s *ParameterStore) Set(p *Parameters) uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	params := *p
+	s.publish(&params)
+	return s.version
+}
+
+func (s *ParameterStore) publish(p *Parameters) {
+	s.version++
+	s.current.Store(&versioned{params: p, version: s.version})
+	for ch := range s.subs {
+		// subscribers only care about the latest snapshot, so replace one they haven't
+		// picked up yet rather than block
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- p
+	}
+}
+
+// Subscribe returns a channel that receives each new snapshot until done is closed. A slow
+// subscriber only sees the latest one.
+func (s *ParameterStore) Subscribe(done chan struct{}) chan *Parameters {
+	ch := make(chan *Parameters, 1)
+	s.lock.Lock()
+	s.subs[ch] = struct{}{}
+	s.lock.Unlock()
+
+	go func() {
+		<-done
+		s.lock.Lock()
+		delete(s.subs, ch)
+		s.lock.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
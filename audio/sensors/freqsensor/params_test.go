@@ -0,0 +1,75 @@
+package freqsensor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParameterStore(t *testing.T) {
+	s := NewParameterStore(DefaultParameters)
+	first := s.Load()
+	if first == DefaultParameters || *first != *DefaultParameters {
+		t.Fatal("expected the store to hold a copy of the parameters")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	sub := s.Subscribe(done)
+
+	s.Update(func(p *Parameters) { p.Gain = 3 })
+	s.Update(func(p *Parameters) { p.Period = 5 })
+	if first.Gain != DefaultParameters.Gain {
+		t.Error("expected an old snapshot not to change")
+	}
+
+	select {
+	case p := <-sub:
+		// only the latest change is kept for a slow subscriber
+		if p.Gain != 3 || p.Period != 5 {
+			t.Errorf("unexpected snapshot %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a notification")
+	}
+	select {
+	case p := <-sub:
+		t.Error("expected a single notification, got", p)
+	default:
+	}
+}
+
+// TestConcurrentUpdates is meant to run with -race.
+func TestConcurrentUpdates(t *testing.T) {
+	d := newTestSensor(t, nil)
+	done := make(chan struct{})
+	in := make(chan []float64)
+	out := d.ProcessBuckets(done, in)
+
+	go func() {
+		defer close(in)
+		for i := 0; i < 100; i++ {
+			select {
+			case in <- make([]float64, 16):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			d.Query(`mutation { params(params: {gain: 3, mode: 0}) { gain } }`, nil)
+			d.Query(`mutation { rawFilter(type: "amp", raw: [0.5, 0.5, 0, 1]) }`, nil)
+			if err := d.SetFilterParams("diff", 1, 1, 4); err != nil {
+				t.Error(err)
+			}
+		}
+		close(done)
+	}()
+
+	for range out {
+	}
+	if d.Parameters().Load().Gain != 3 {
+		t.Error("expected the mutation to be applied")
+	}
+}
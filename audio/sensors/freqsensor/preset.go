@@ -0,0 +1,438 @@
+package freqsensor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Preset is a saved state of everything that tunes the sensor.
+type Preset struct {
+	Name       string        `json:"name" yaml:"name"`
+	Parameters *Parameters   `json:"params" yaml:"params"`
+	Filter     *FilterPreset `json:"filter,omitempty" yaml:"filter,omitempty"`
+	VGC        *VGCPreset    `json:"vgc,omitempty" yaml:"vgc,omitempty"`
+	// FrameRate is the frame rate the filter and vgc coefficients are for. They're retuned
+	// when the preset is applied at a different rate.
+	FrameRate float64 `json:"frameRate,omitempty" yaml:"frameRate,omitempty"`
+}
+
+// FilterPreset holds the raw coefficients of the amplitude and differential filter chains,
+// in the same layout as the rawFilter mutation, and the time constants that override the
+// parameters' curves.
+type FilterPreset struct {
+	Amp   []float64      `json:"amp" yaml:"amp"`
+	Diff  []float64      `json:"diff" yaml:"diff"`
+	Times *TimeConstants `json:"times,omitempty" yaml:"times,omitempty"`
+}
+
+// VGCPreset holds the settings of the variable gain controller.
+type VGCPreset struct {
+	// Filter is the lowpass on the level of each bucket that the gains follow.
+	Filter []float64 `json:"filter" yaml:"filter"`
+	// Kp and Kd are the proportional and differential gains of the controller.
+	Kp float64 `json:"kp" yaml:"kp"`
+	Kd float64 `json:"kd" yaml:"kd"`
+	// Target is the level the controller holds each bucket at. It defaults to 1.
+	Target float64 `json:"target" yaml:"target"`
+	// MinGain and MaxGain limit the gains. MaxGain defaults to 10000.
+	MinGain float64 `json:"minGain" yaml:"minGain"`
+	MaxGain float64 `json:"maxGain" yaml:"maxGain"`
+	// Curve maps the distance from the target to the error: "quadratic", "sigmoid" or
+	// "log". It defaults to "quadratic".
+	Curve string `json:"curve" yaml:"curve"`
+}
+
+// Preset captures the current state of the sensor as a preset called @name.
+func (d *FrequencySensor) Preset(name string) *Preset {
+	params := *d.paramStore.Load()
+	t := d.loadTuning()
+	p := &Preset{
+		Name:       name,
+		Parameters: &params,
+		Filter: &FilterPreset{
+			Amp:  copyFloats(t.filter.gain.RawMatrix().Data),
+			Diff: copyFloats(t.filter.diff.RawMatrix().Data),
+		},
+		VGC:       t.vgc.preset(),
+		FrameRate: t.frameRate,
+	}
+	if len(t.times.Attack) > 0 || len(t.times.Release) > 0 {
+		p.Filter.Times = &TimeConstants{
+			Attack:  copyFloats(t.times.Attack),
+			Release: copyFloats(t.times.Release),
+		}
+	}
+	return p
+}
+
+// ApplyPreset sets the sensor's state from @p. Parts of the preset that are missing are
+// left as they are. The preset is checked before anything changes, and its parameters and
+// tuning are published together, so no frame uses one without the other.
+func (d *FrequencySensor) ApplyPreset(p *Preset) error {
+	return d.applyPreset(p, 0)
+}
+
+// applyPreset is ApplyPreset with a crossfade of @fade seconds to the preset's parameters,
+// which is armed along with them.
+func (d *FrequencySensor) applyPreset(p *Preset, fade float64) error {
+	var amp, diff *mat.Dense
+	if f := p.Filter; f != nil {
+		var err error
+		if amp, err = newFilterChain(f.Amp); err != nil {
+			return fmt.Errorf("preset %s: amp: %v", p.Name, err)
+		}
+		if diff, err = newFilterChain(f.Diff); err != nil {
+			return fmt.Errorf("preset %s: diff: %v", p.Name, err)
+		}
+		if f.Times != nil {
+			if err := f.Times.Validate(); err != nil {
+				return fmt.Errorf("preset %s: %v", p.Name, err)
+			}
+		}
+	}
+	var vgc vgcParams
+	if v := p.VGC; v != nil {
+		var err error
+		if vgc, err = v.params(); err != nil {
+			return fmt.Errorf("preset %s: %v", p.Name, err)
+		}
+	}
+	if p.Parameters != nil {
+		if err := ValidateParameters(p.Parameters); err != nil {
+			return fmt.Errorf("preset %s: %v", p.Name, err)
+		}
+	}
+
+	d.tuneLock.Lock()
+	defer d.tuneLock.Unlock()
+	if p.Parameters != nil {
+		version := d.paramStore.Set(p.Parameters)
+		if fade > 0 {
+			d.slewer.Crossfade(version, fade)
+		}
+	}
+	return d.updateTuningLocked(func(t *tuning) error {
+		// the preset's coefficients are retimed from its rate
+		factor := 1.0
+		if p.FrameRate > 0 {
+			factor = t.frameRate / p.FrameRate
+		}
+		if f := p.Filter; f != nil {
+			t.filter = filterValues{
+				gain: retimeChain(amp, factor),
+				diff: retimeChain(diff, factor),
+			}
+			t.times = TimeConstants{}
+			if f.Times != nil {
+				t.times = TimeConstants{
+					Attack:  copyFloats(f.Times.Attack),
+					Release: copyFloats(f.Times.Release),
+				}
+			}
+		}
+		if p.VGC != nil {
+			vgc.frozen, vgc.resets = t.vgc.frozen, t.vgc.resets
+			vgc.filter = retimeVGC(vgc.filter, factor)
+			t.vgc = vgc
+		}
+		d.preset = p.Name
+		return nil
+	})
+}
+
+// CurrentPreset returns the name of the preset that was last applied or saved.
+func (d *FrequencySensor) CurrentPreset() string {
+	d.tuneLock.Lock()
+	defer d.tuneLock.Unlock()
+	return d.preset
+}
+
+// RecallPreset applies the preset called @name from the sensor's library. Its parameters
+// crossfade in over @fade seconds; the filters and gain controller change at once.
+func (d *FrequencySensor) RecallPreset(name string, fade float64) error {
+	if d.presets == nil {
+		return errors.New("no preset library is configured")
+	}
+	p, err := d.presets.Load(name)
+	if err != nil {
+		return err
+	}
+	return d.applyPreset(p, fade)
+}
+
+// SavePreset saves the current state to the sensor's library as @name.
+func (d *FrequencySensor) SavePreset(name string) error {
+	if d.presets == nil {
+		return errors.New("no preset library is configured")
+	}
+	if err := d.presets.Save(d.Preset(name)); err != nil {
+		return err
+	}
+	d.tuneLock.Lock()
+	d.preset = name
+	d.tuneLock.Unlock()
+	return nil
+}
+
+// WatchPresets reapplies the current preset whenever its file in the library changes. It
+// checks for changes every @interval until done is closed.
+func (d *FrequencySensor) WatchPresets(done chan struct{}, interval time.Duration) {
+	if d.presets == nil {
+		return
+	}
+	changed := d.presets.Watch(done, interval)
+	go func() {
+		for p := range changed {
+			if p.Name != d.CurrentPreset() {
+				continue
+			}
+			if err := d.ApplyPreset(p); err != nil {
+				log.Println("[ERROR] presets:", err)
+				continue
+			}
+			log.Println("[INFO] presets: reloaded", p.Name)
+		}
+	}()
+}
+
+func copyFloats(x []float64) []float64 {
+	return append([]float64(nil), x...)
+}
+
+// isYAML tells whether a preset file should be read as YAML rather than JSON.
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadPreset reads a preset from a JSON or YAML file. A preset without a name is named
+// after its file. Parameters that its params leave out have their defaults.
+func LoadPreset(path string) (*Preset, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decode := json.Unmarshal
+	if isYAML(path) {
+		decode = yaml.Unmarshal
+	}
+	p := new(Preset)
+	if err := decode(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if p.Parameters != nil {
+		// decode the params again over the defaults, so that a hand written preset can
+		// give just the ones it changes
+		params := *DefaultParameters
+		over := struct {
+			Parameters *Parameters `json:"params" yaml:"params"`
+		}{&params}
+		if err := decode(data, &over); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		p.Parameters = &params
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return p, nil
+}
+
+// SavePreset writes @p to a JSON or YAML file, depending on the extension of @path.
+func SavePreset(path string, p *Preset) error {
+	var data []byte
+	var err error
+	if isYAML(path) {
+		data, err = yaml.Marshal(p)
+	} else {
+		data, err = json.MarshalIndent(p, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	// write and rename so watchers never see a partial file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+var presetExts = []string{".json", ".yaml", ".yml"}
+
+// PresetLibrary is a directory of preset files, which are named after the presets in them.
+type PresetLibrary struct {
+	Dir string
+}
+
+// NewPresetLibrary opens the library in @dir, creating the directory if it doesn't exist.
+func NewPresetLibrary(dir string) (*PresetLibrary, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &PresetLibrary{Dir: dir}, nil
+}
+
+// presetFile is the file a preset is kept in. A name that's claimed by more than one file,
+// such as a.json and a.yaml, has an error instead, which only affects that preset.
+type presetFile struct {
+	path string
+	err  error
+}
+
+// files returns the preset files in the library by name.
+func (l *PresetLibrary) files() (map[string]presetFile, error) {
+	entries, err := ioutil.ReadDir(l.Dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]presetFile)
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		for _, pe := range presetExts {
+			if !e.Mode().IsRegular() || !strings.EqualFold(ext, pe) {
+				continue
+			}
+			name := strings.TrimSuffix(e.Name(), ext)
+			if other, ok := files[name]; ok {
+				if other.err == nil {
+					other.err = fmt.Errorf("preset %s is in both %s and %s",
+						name, filepath.Base(other.path), e.Name())
+				}
+				files[name] = other
+				continue
+			}
+			files[name] = presetFile{path: filepath.Join(l.Dir, e.Name())}
+		}
+	}
+	return files, nil
+}
+
+// Names returns the names of the presets in the library in order, including any that
+// can't be loaded because they're in more than one file.
+func (l *PresetLibrary) Names() ([]string, error) {
+	files, err := l.files()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load reads the preset called @name.
+func (l *PresetLibrary) Load(name string) (*Preset, error) {
+	files, err := l.files()
+	if err != nil {
+		return nil, err
+	}
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset: %q", name)
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	p, err := LoadPreset(f.path)
+	if err != nil {
+		return nil, err
+	}
+	p.Name = name
+	return p, nil
+}
+
+// Save writes @p to the library. An existing file for the preset keeps its format,
+// otherwise it's saved as JSON.
+func (l *PresetLibrary) Save(p *Preset) error {
+	if p.Name == "" || strings.ContainsAny(p.Name, `/\`) {
+		return fmt.Errorf("invalid preset name: %q", p.Name)
+	}
+	files, err := l.files()
+	if err != nil {
+		return err
+	}
+	f, ok := files[p.Name]
+	if !ok {
+		f.path = filepath.Join(l.Dir, p.Name+".json")
+	}
+	if f.err != nil {
+		return f.err
+	}
+	return SavePreset(f.path, p)
+}
+
+// Watch checks the library for changed or new presets every @interval and sends them on
+// the returned channel until done is closed.
+func (l *PresetLibrary) Watch(done chan struct{}, interval time.Duration) chan *Preset {
+	out := make(chan *Preset)
+
+	modTimes := func() map[string]time.Time {
+		files, err := l.files()
+		if err != nil {
+			log.Println("[ERROR] presets:", err)
+			return nil
+		}
+		times := make(map[string]time.Time)
+		for name, f := range files {
+			if f.err != nil {
+				// it's reported once, when it appears
+				times[name] = time.Time{}
+			} else if info, err := os.Stat(f.path); err == nil {
+				times[name] = info.ModTime()
+			}
+		}
+		return times
+	}
+
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := modTimes()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			current := modTimes()
+			if current == nil {
+				continue
+			}
+			for name, t := range current {
+				if prev, ok := last[name]; ok && prev.Equal(t) {
+					continue
+				}
+				p, err := l.Load(name)
+				if err != nil {
+					log.Println("[ERROR] presets:", err)
+					continue
+				}
+				select {
+				case out <- p:
+				case <-done:
+					return
+				}
+			}
+			last = current
+		}
+	}()
+
+	return out
+}
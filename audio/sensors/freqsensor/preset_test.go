@@ -0,0 +1,226 @@
+package freqsensor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSensor(t *testing.T, presets *PresetLibrary) *FrequencySensor {
+	params := *DefaultParameters
+	return NewFrequencySensor(&Config{
+		Buckets:    16,
+		Columns:    4,
+		SampleRate: 44100,
+		Parameters: &params,
+		Presets:    presets,
+	})
+}
+
+func TestPresetFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newTestSensor(t, nil)
+	p := d.Preset("")
+	p.Parameters.Gain = 5
+	p.VGC.Kd = 8
+
+	for _, name := range []string{"a.json", "b.yaml"} {
+		path := filepath.Join(dir, name)
+		if err := SavePreset(path, p); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadPreset(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := *p
+		want.Name = name[:1]
+		if !reflect.DeepEqual(got, &want) {
+			t.Errorf("%s: expected %+v, got %+v", name, want, got)
+		}
+	}
+
+	lib, err := NewPresetLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := lib.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Error("unexpected names", names)
+	}
+
+	// a hand written preset only gives the parameters it changes
+	for name, data := range map[string]string{
+		"c.json": `{"params": {"gain": 3}}`,
+		"d.yaml": "params:\n  gain: 3\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadPreset(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := *DefaultParameters
+		want.Gain = 3
+		if !reflect.DeepEqual(got.Parameters, &want) {
+			t.Errorf("%s: expected the defaults with a gain of 3, got %+v", name, got.Parameters)
+		}
+		if err := ValidateParameters(got.Parameters); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.yaml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// only the preset that's in two files fails
+	if _, err := lib.Load("a"); err == nil {
+		t.Error("expected an error for a.json and a.yaml")
+	}
+	if err := lib.Save(&Preset{Name: "a"}); err == nil {
+		t.Error("expected saving a preset that's in two files to fail")
+	}
+	if _, err := lib.Load("b"); err != nil {
+		t.Error(err)
+	}
+	if names, err = lib.Names(); err != nil || len(names) != 4 {
+		t.Errorf("expected the names of all 4 presets, got %v, %v", names, err)
+	}
+}
+
+func TestPresetGraphql(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lib, err := NewPresetLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestSensor(t, lib)
+
+	res := d.Query(`mutation { savePreset(name: "loud") }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+
+	d.Parameters().Update(func(p *Parameters) { p.Gain = 0 })
+	if err := d.SetRawFilter("amp", []float64{1, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	res = d.Query(`mutation { recallPreset(name: "loud") { gain } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	amp, _ := d.FilterParams("amp")
+	if d.Parameters().Load().Gain != DefaultParameters.Gain || amp[0] == 1 {
+		t.Error("expected the preset to be restored, got", d.Parameters().Load().Gain, amp)
+	}
+	if d.CurrentPreset() != "loud" {
+		t.Error("expected the current preset to be loud, got", d.CurrentPreset())
+	}
+
+	res = d.Query(`mutation { recallPreset(name: "quiet") { gain } }`, nil)
+	if len(res.Errors) == 0 {
+		t.Error("expected recalling a missing preset to fail")
+	}
+}
+
+func TestPresetWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lib, err := NewPresetLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestSensor(t, lib)
+	p := d.Preset("live")
+	if err := lib.Save(p); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	changed := lib.Watch(done, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	p.Parameters.Period = 7
+	if err := lib.Save(p); err != nil {
+		t.Fatal(err)
+	}
+	// make sure the change is visible on filesystems with coarse modification times
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(filepath.Join(dir, "live.json"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-changed:
+		if got.Name != "live" || got.Parameters.Period != 7 {
+			t.Errorf("unexpected preset %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the change to be noticed")
+	}
+}
+
+func TestApplyPresetAtomic(t *testing.T) {
+	d := newTestSensor(t, nil)
+	a := d.Preset("a")
+	a.Parameters.Gain = 1
+	a.Filter.Amp = []float64{0.5, 0.5, 0, 1}
+	b := d.Preset("b")
+	b.Parameters.Gain = 2
+	b.Filter.Amp = []float64{0.25, 0.75, 0, 1}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			p := a
+			if i%2 == 1 {
+				p = b
+			}
+			if err := d.ApplyPreset(p); err != nil {
+				panic(err)
+			}
+		}
+	}()
+
+	// each frame uses the gain and the filter of the same preset
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64)
+	defer close(in)
+	out := d.ProcessBuckets(done, in)
+	for i := 0; i < 5000; i++ {
+		in <- make([]float64, d.Buckets)
+		<-out
+		gain, coeff := d.params.Gain, d.filterParams.gain.At(0, 0)
+		if (gain == 1) != (coeff == 0.5) {
+			t.Fatalf("frame %d mixed presets: gain %v with filter %v", i, gain, coeff)
+		}
+	}
+}
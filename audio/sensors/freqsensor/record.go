@@ -0,0 +1,255 @@
+package freqsensor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// recordingMagic starts every recording, followed by a byte with the version of the format.
+const recordingMagic = "VZDR"
+
+const recordingVersion = 1
+
+// A recording is the magic and version, then each frame as
+//
+//	u32 length of the frame in bytes, not counting these 12
+//	i64 ns since the first frame
+//	    the drivers as AppendDriversBinary encodes them
+
+// RecordedFrame is a frame of a recording and when it came, relative to the first frame.
+type RecordedFrame struct {
+	Offset  time.Duration
+	Drivers *Drivers
+}
+
+// Recorder writes the output of a sensor to a recording. It's safe to flush it while it
+// records.
+type Recorder struct {
+	lock   sync.Mutex
+	w      *bufio.Writer
+	fields DriverFields
+	start  time.Time
+	frames int
+	buf    []byte
+	err    error
+}
+
+// NewRecorder starts a recording of @fields of each frame on @w.
+func NewRecorder(w io.Writer, fields DriverFields) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w), fields: fields}
+	if _, err := r.w.WriteString(recordingMagic); err != nil {
+		return nil, err
+	}
+	if err := r.w.WriteByte(recordingVersion); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write adds @drv to the recording. Frames are timed by their Time, or by when they're
+// written if it's not set.
+func (r *Recorder) Write(drv *Drivers) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	at := drv.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	if r.frames == 0 {
+		r.start = at
+	}
+	r.frames++
+
+	le := binary.LittleEndian
+	r.buf = le.AppendUint32(r.buf[:0], 0)
+	r.buf = le.AppendUint64(r.buf, uint64(at.Sub(r.start)))
+	r.buf = AppendDriversBinary(r.buf, drv, r.fields)
+	le.PutUint32(r.buf, uint32(len(r.buf)-12))
+	_, r.err = r.w.Write(r.buf)
+	return r.err
+}
+
+// Flush writes any buffered frames to the underlying writer.
+func (r *Recorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+// Frames returns how many frames have been recorded.
+func (r *Recorder) Frames() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.frames
+}
+
+// Process passes the frames from @in through, and records each one. The recording is
+// flushed when @in closes. If writing fails, the error is logged and the frames keep
+// passing through unrecorded.
+func (r *Recorder) Process(done chan struct{}, in chan *Drivers) chan *Drivers {
+	out := make(chan *Drivers)
+	go func() {
+		defer close(out)
+		failed := false
+		defer func() {
+			if err := r.Flush(); err != nil && !failed {
+				log.Println("[ERROR] recording drivers:", err)
+			}
+		}()
+		for drv := range in {
+			if err := r.Write(drv); err != nil && !failed {
+				log.Println("[ERROR] recording drivers:", err)
+				failed = true
+			}
+			select {
+			case out <- drv:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// ReadRecording reads all of the frames of a recording. A recording whose last frame was
+// cut off, as happens when the recorder is killed, ends with the frame before it.
+func ReadRecording(rd io.Reader) ([]RecordedFrame, error) {
+	br := bufio.NewReader(rd)
+	head := make([]byte, len(recordingMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, fmt.Errorf("reading recording: %v", err)
+	}
+	if !bytes.Equal(head[:len(recordingMagic)], []byte(recordingMagic)) {
+		return nil, errors.New("not a recording of drivers")
+	}
+	if v := head[len(recordingMagic)]; v != recordingVersion {
+		return nil, fmt.Errorf("unknown recording version %d", v)
+	}
+
+	var frames []RecordedFrame
+	le := binary.LittleEndian
+	prefix := make([]byte, 12)
+	var data []byte
+	for {
+		if _, err := io.ReadFull(br, prefix); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return frames, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading frame %d: %v", len(frames), err)
+		}
+		n := int(le.Uint32(prefix))
+		if cap(data) < n {
+			data = make([]byte, n)
+		}
+		data = data[:n]
+		if _, err := io.ReadFull(br, data); err == io.ErrUnexpectedEOF || err == io.EOF {
+			return frames, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading frame %d: %v", len(frames), err)
+		}
+		drv, _, _, err := ReadDriversBinary(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading frame %d: %v", len(frames), err)
+		}
+		frames = append(frames, RecordedFrame{
+			Offset:  time.Duration(le.Uint64(prefix[4:])),
+			Drivers: drv,
+		})
+	}
+}
+
+// LoadRecording reads the recording in the file @path.
+func LoadRecording(path string) ([]RecordedFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecording(f)
+}
+
+// Replayer plays a recording back as if it came from a sensor.
+type Replayer struct {
+	// Speed is how much faster than recorded the frames are played. Zero plays them as fast
+	// as they're taken.
+	Speed float64
+	// Loop starts the recording over when it ends.
+	Loop bool
+
+	frames []RecordedFrame
+}
+
+// NewReplayer creates a replayer of @frames at the original speed.
+func NewReplayer(frames []RecordedFrame) *Replayer {
+	return &Replayer{Speed: 1, frames: frames}
+}
+
+// Play sends the frames of the recording at their times. Each one is a copy that's stamped
+// with the time it's sent, and the sequence numbers and sample positions keep counting up
+// when the recording loops. The channel closes at the end of the recording, unless it
+// loops, or when @done closes.
+func (p *Replayer) Play(done chan struct{}) chan *Drivers {
+	out := make(chan *Drivers)
+	go func() {
+		defer close(out)
+		if len(p.frames) == 0 {
+			return
+		}
+		first, last := p.frames[0], p.frames[len(p.frames)-1]
+		// one loop of the recording, with room for the last frame
+		var span time.Duration
+		var spanSeq uint64
+		var spanSamples int64
+		if n := len(p.frames); n > 1 {
+			span = last.Offset + (last.Offset-first.Offset)/time.Duration(n-1)
+			spanSeq = last.Drivers.Seq - first.Drivers.Seq + 1
+			spanSamples = last.Drivers.SamplePosition - first.Drivers.SamplePosition +
+				(last.Drivers.SamplePosition-first.Drivers.SamplePosition)/int64(n-1)
+		} else {
+			span, spanSeq = time.Second, 1
+		}
+
+		start := time.Now()
+		for loop := 0; ; loop++ {
+			for _, f := range p.frames {
+				at := f.Offset + time.Duration(loop)*span
+				if p.Speed > 0 {
+					if wait := time.Until(start.Add(time.Duration(float64(at) / p.Speed))); wait > 0 {
+						select {
+						case <-time.After(wait):
+						case <-done:
+							return
+						}
+					}
+				}
+				drv := f.Drivers.Copy()
+				drv.Seq += uint64(loop) * spanSeq
+				drv.SamplePosition += int64(loop) * spanSamples
+				drv.Time = time.Now()
+				select {
+				case out <- drv:
+				case <-done:
+					return
+				}
+			}
+			if !p.Loop {
+				return
+			}
+		}
+	}()
+	return out
+}
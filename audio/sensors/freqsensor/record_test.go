@@ -0,0 +1,85 @@
+package freqsensor
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, AllFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan *Drivers)
+	out := rec.Process(done, in)
+	start := time.Unix(100, 0)
+	go func() {
+		defer close(in)
+		for seq := uint64(0); seq < 4; seq++ {
+			drv := testDrivers(seq)
+			drv.Time = start.Add(time.Duration(seq) * 20 * time.Millisecond)
+			in <- drv
+		}
+	}()
+	for range out {
+	}
+	if rec.Frames() != 4 {
+		t.Fatal("expected 4 frames to be recorded, got", rec.Frames())
+	}
+
+	frames, err := ReadRecording(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 4 || frames[3].Offset != 60*time.Millisecond ||
+		frames[3].Drivers.Seq != 3 || frames[3].Drivers.Amplitude[1][0] != 4 {
+		t.Fatalf("unexpected recording %+v", frames)
+	}
+	// a recording that was cut off keeps the frames before the cut
+	for _, cut := range []int{1, buf.Len() / 8} {
+		got, err := ReadRecording(bytes.NewReader(buf.Bytes()[:buf.Len()-cut]))
+		if err != nil || len(got) != 3 {
+			t.Errorf("expected 3 frames of a truncated recording, got %d, %v", len(got), err)
+		}
+	}
+	if _, err := ReadRecording(bytes.NewReader([]byte("VZDR"))); err == nil {
+		t.Error("expected an error for a recording without a header")
+	}
+
+	// twice as fast, the 4 frames and the gap before the loop take 40ms
+	p := NewReplayer(frames)
+	p.Speed, p.Loop = 2, true
+	stop := make(chan struct{})
+	played := p.Play(stop)
+	began := time.Now()
+	var last *Drivers
+	for i := 0; i < 6; i++ {
+		last = <-played
+	}
+	close(stop)
+	if elapsed := time.Since(began); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the replay to take its time, took %v", elapsed)
+	}
+	if last.Seq != 5 || last.SamplePosition != 5*1024 {
+		t.Errorf("expected the looped frame to keep counting, got %d at %d",
+			last.Seq, last.SamplePosition)
+	}
+	if last.Time.Before(began) {
+		t.Error("expected the frame to be stamped when it's played")
+	}
+
+	// as fast as possible, ending with the recording
+	p = NewReplayer(frames)
+	p.Speed = 0
+	n := 0
+	for range p.Play(done) {
+		n++
+	}
+	if n != 4 {
+		t.Errorf("expected 4 frames, got %d", n)
+	}
+}
@@ -0,0 +1,404 @@
+package freqsensor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Playlist orders
+const (
+	SequenceOrder = "sequence"
+	RandomOrder   = "random"
+)
+
+// Playlist is a list of scenes that a Scheduler steps through.
+type Playlist struct {
+	Name string `json:"name" yaml:"name"`
+	// Order is "sequence" or "random", which picks the next scene by weight.
+	Order string `json:"order" yaml:"order"`
+	// Fade is the crossfade between scenes in seconds, unless a scene has its own.
+	Fade   float64 `json:"fade" yaml:"fade"`
+	Scenes []Scene `json:"scenes" yaml:"scenes"`
+	// Rules restrict the scenes that play at certain times of day. The first rule that
+	// matches the time applies, and all scenes play when none do.
+	Rules []TimeRule `json:"rules" yaml:"rules"`
+}
+
+// Scene is a preset in a playlist along with when to move on from it. A scene that has
+// neither a duration nor a beat count plays until it's skipped.
+type Scene struct {
+	Preset string `json:"preset" yaml:"preset"`
+	// Duration is how long the scene plays in seconds.
+	Duration float64 `json:"duration" yaml:"duration"`
+	// Beats is how many beats the scene plays for.
+	Beats int `json:"beats" yaml:"beats"`
+	// Weight is the relative chance of the scene in random order. It defaults to 1.
+	Weight float64 `json:"weight" yaml:"weight"`
+	// Fade overrides the playlist's crossfade into this scene if it's positive.
+	Fade float64 `json:"fade" yaml:"fade"`
+}
+
+// TimeRule limits a playlist to some presets between two times of day, given as "15:04".
+// A rule whose From is after its To spans midnight.
+type TimeRule struct {
+	From    string   `json:"from" yaml:"from"`
+	To      string   `json:"to" yaml:"to"`
+	Presets []string `json:"presets" yaml:"presets"`
+
+	from, to int // minutes since midnight
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (r *TimeRule) matches(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if r.from <= r.to {
+		return r.from <= m && m < r.to
+	}
+	return m >= r.from || m < r.to
+}
+
+func (r *TimeRule) allows(preset string) bool {
+	for _, p := range r.Presets {
+		if p == preset {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the playlist and fills in defaults.
+func (p *Playlist) Validate() error {
+	switch p.Order {
+	case "":
+		p.Order = SequenceOrder
+	case SequenceOrder, RandomOrder:
+	default:
+		return fmt.Errorf("playlist %s: unknown order %q", p.Name, p.Order)
+	}
+	if len(p.Scenes) == 0 {
+		return fmt.Errorf("playlist %s has no scenes", p.Name)
+	}
+	for i := range p.Scenes {
+		s := &p.Scenes[i]
+		if s.Preset == "" {
+			return fmt.Errorf("playlist %s: scene %d has no preset", p.Name, i)
+		}
+		if s.Duration < 0 || s.Beats < 0 || s.Weight < 0 {
+			return fmt.Errorf("playlist %s: scene %d has a negative setting", p.Name, i)
+		}
+		if s.Weight == 0 {
+			s.Weight = 1
+		}
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		var err error
+		if r.from, err = parseTimeOfDay(r.From); err != nil {
+			return fmt.Errorf("playlist %s: rule %d: %v", p.Name, i, err)
+		}
+		if r.to, err = parseTimeOfDay(r.To); err != nil {
+			return fmt.Errorf("playlist %s: rule %d: %v", p.Name, i, err)
+		}
+	}
+	return nil
+}
+
+// LoadPlaylist reads a playlist from a JSON or YAML file.
+func LoadPlaylist(path string) (*Playlist, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Playlist)
+	if isYAML(path) {
+		err = yaml.Unmarshal(data, p)
+	} else {
+		err = json.Unmarshal(data, p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SceneState is the position of a Scheduler in its playlist.
+type SceneState struct {
+	Playlist string  `json:"playlist"`
+	Index    int     `json:"index"`
+	Preset   string  `json:"preset"`
+	Elapsed  float64 `json:"elapsed"`
+	Beats    int     `json:"beats"`
+	Held     bool    `json:"held"`
+}
+
+// Scheduler steps through the scenes of a playlist, recalling the preset of each one. Its
+// position is saved to a file so that it picks up where it left off after a restart.
+type Scheduler struct {
+	// counted is the beats since the last Step, which are counted without the lock so that
+	// the audio goroutine never waits on a recall.
+	counted int64
+
+	lock     sync.Mutex
+	playlist *Playlist
+	recall   func(preset string, fade float64) error
+	path     string
+
+	index   int
+	elapsed float64
+	beats   int
+	held    bool
+	started bool
+
+	// now is the clock for the time of day rules.
+	now func() time.Time
+}
+
+// NewScheduler creates a scheduler for @playlist that switches scenes with @recall, which
+// is usually FrequencySensor.RecallPreset. If @statePath isn't empty, the position is
+// restored from and saved to it.
+func NewScheduler(playlist *Playlist, recall func(preset string, fade float64) error,
+	statePath string) (*Scheduler, error) {
+
+	if err := playlist.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Scheduler{
+		playlist: playlist,
+		recall:   recall,
+		path:     statePath,
+		now:      time.Now,
+	}
+
+	if statePath != "" {
+		data, err := ioutil.ReadFile(statePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		var state SceneState
+		if err == nil && json.Unmarshal(data, &state) == nil &&
+			state.Playlist == playlist.Name && state.Index >= 0 && state.Index < len(playlist.Scenes) {
+			s.index = state.Index
+			s.held = state.Held
+		}
+	}
+	return s, nil
+}
+
+// Start recalls the current scene.
+func (s *Scheduler) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.started = true
+	if !s.allowed(s.index) {
+		return s.advance()
+	}
+	return s.enter(s.index, 0)
+}
+
+// candidates returns the scenes that may play now.
+func (s *Scheduler) candidates() []int {
+	var rule *TimeRule
+	now := s.now()
+	for i := range s.playlist.Rules {
+		if s.playlist.Rules[i].matches(now) {
+			rule = &s.playlist.Rules[i]
+			break
+		}
+	}
+	var idx []int
+	for i, sc := range s.playlist.Scenes {
+		if rule == nil || rule.allows(sc.Preset) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func (s *Scheduler) allowed(index int) bool {
+	for _, i := range s.candidates() {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// next picks the scene after the current one.
+func (s *Scheduler) next() (int, error) {
+	cand := s.candidates()
+	if len(cand) == 0 {
+		return 0, errors.New("no scene is allowed at this time")
+	}
+
+	if s.playlist.Order == RandomOrder {
+		// don't repeat the current scene if there's a choice
+		var total float64
+		for _, i := range cand {
+			if i != s.index || len(cand) == 1 {
+				total += s.playlist.Scenes[i].Weight
+			}
+		}
+		x := rand.Float64() * total
+		for _, i := range cand {
+			if i == s.index && len(cand) > 1 {
+				continue
+			}
+			x -= s.playlist.Scenes[i].Weight
+			if x < 0 {
+				return i, nil
+			}
+		}
+		return cand[len(cand)-1], nil
+	}
+
+	for _, i := range cand {
+		if i > s.index {
+			return i, nil
+		}
+	}
+	return cand[0], nil
+}
+
+func (s *Scheduler) enter(index int, fade float64) error {
+	s.index = index
+	s.elapsed = 0
+	s.beats = 0
+	atomic.StoreInt64(&s.counted, 0)
+	s.save()
+	return s.recall(s.playlist.Scenes[index].Preset, fade)
+}
+
+func (s *Scheduler) advance() error {
+	i, err := s.next()
+	if err != nil {
+		return err
+	}
+	fade := s.playlist.Fade
+	if f := s.playlist.Scenes[i].Fade; f > 0 {
+		fade = f
+	}
+	return s.enter(i, fade)
+}
+
+func (s *Scheduler) due() bool {
+	if s.held {
+		return false
+	}
+	sc := s.playlist.Scenes[s.index]
+	return (sc.Duration > 0 && s.elapsed >= sc.Duration) ||
+		(sc.Beats > 0 && s.beats >= sc.Beats)
+}
+
+// Step advances the scheduler's clock by @dt seconds, adds the beats counted since the last
+// step and moves to the next scene if the current one is done, or isn't allowed at this
+// time of day anymore.
+func (s *Scheduler) Step(dt float64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	beats := int(atomic.SwapInt64(&s.counted, 0))
+	if !s.started {
+		return nil
+	}
+	s.elapsed += dt
+	s.beats += beats
+	if s.due() || (!s.held && !s.allowed(s.index)) {
+		return s.advance()
+	}
+	return nil
+}
+
+// Beat counts a beat toward scenes that last a number of beats. It doesn't block, so it's
+// safe to call from the audio goroutine; the scene changes on the next Step.
+func (s *Scheduler) Beat() {
+	atomic.AddInt64(&s.counted, 1)
+}
+
+// Skip moves to the next scene now, even if the scheduler is held.
+func (s *Scheduler) Skip() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.advance()
+}
+
+// Hold keeps the scheduler on the current scene until it's released.
+func (s *Scheduler) Hold(hold bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.held = hold
+	s.save()
+}
+
+// Current returns the scheduler's position.
+func (s *Scheduler) Current() SceneState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state()
+}
+
+func (s *Scheduler) state() SceneState {
+	return SceneState{
+		Playlist: s.playlist.Name,
+		Index:    s.index,
+		Preset:   s.playlist.Scenes[s.index].Preset,
+		Elapsed:  s.elapsed,
+		Beats:    s.beats,
+		Held:     s.held,
+	}
+}
+
+func (s *Scheduler) save() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.Marshal(s.state())
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		log.Println("[ERROR] scheduler:", err)
+	}
+}
+
+// Run steps the scheduler every @interval until done is closed. The recalls and saves of
+// scene changes happen on its goroutine.
+func (s *Scheduler) Run(done chan struct{}, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := s.Step(now.Sub(last).Seconds()); err != nil {
+					log.Println("[ERROR] scheduler:", err)
+				}
+				last = now
+			}
+		}
+	}()
+}
@@ -0,0 +1,128 @@
+package freqsensor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "scene.json")
+
+	var recalled []string
+	recall := func(preset string, fade float64) error {
+		recalled = append(recalled, preset)
+		return nil
+	}
+	newPlaylist := func() *Playlist {
+		return &Playlist{
+			Name: "test",
+			Fade: 2,
+			Scenes: []Scene{
+				{Preset: "a", Duration: 10},
+				{Preset: "b", Beats: 4},
+				{Preset: "c"},
+			},
+			Rules: []TimeRule{{From: "22:00", To: "06:00", Presets: []string{"c"}}},
+		}
+	}
+	noon := func() time.Time { return time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local) }
+
+	s, err := NewScheduler(newPlaylist(), recall, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = noon
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.Step(9)
+	if s.Current().Preset != "a" {
+		t.Fatal("expected a to still be playing")
+	}
+	s.Step(1)
+	for i := 0; i < 4; i++ {
+		s.Beat()
+	}
+	if got := s.Current().Preset; got != "b" {
+		t.Fatal("expected the beats to wait for the next step, got", got)
+	}
+	s.Step(0)
+	if got := s.Current().Preset; got != "c" {
+		t.Fatal("expected b to be done after 4 beats, got", got)
+	}
+
+	// c plays until it's skipped, and a held scene stays
+	s.Step(1000)
+	s.Hold(true)
+	if err := s.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	s.Step(1000)
+	if got := s.Current().Preset; got != "a" {
+		t.Fatal("expected a to be held, got", got)
+	}
+	if want := []string{"a", "b", "c", "a"}; len(recalled) != len(want) {
+		t.Fatalf("expected %v to be recalled, got %v", want, recalled)
+	}
+
+	// the position survives a restart
+	s, err = NewScheduler(newPlaylist(), recall, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = noon
+	if cur := s.Current(); cur.Preset != "a" || !cur.Held {
+		t.Errorf("expected to resume on a held, got %+v", cur)
+	}
+
+	// a state with a bad index is ignored
+	if err := ioutil.WriteFile(statePath, []byte(`{"playlist":"test","index":-1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := NewScheduler(newPlaylist(), recall, statePath); err != nil || s.Current().Preset != "a" {
+		t.Errorf("expected to start over on a bad index, got %v", err)
+	}
+
+	// at night only c is allowed
+	s.Hold(false)
+	s.now = func() time.Time { return time.Date(2020, 1, 1, 23, 30, 0, 0, time.Local) }
+	s.Start()
+	if got := s.Current().Preset; got != "c" {
+		t.Error("expected the night rule to pick c, got", got)
+	}
+}
+
+func TestSchedulerRandom(t *testing.T) {
+	counts := make(map[string]int)
+	recall := func(preset string, fade float64) error {
+		counts[preset]++
+		return nil
+	}
+	s, err := NewScheduler(&Playlist{
+		Name:  "random",
+		Order: RandomOrder,
+		Scenes: []Scene{
+			{Preset: "a", Duration: 1, Weight: 1},
+			{Preset: "b", Duration: 1, Weight: 1},
+			{Preset: "c", Duration: 1, Weight: 8},
+		},
+	}, recall, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	for i := 0; i < 1000; i++ {
+		s.Step(1)
+	}
+	if counts["c"] < counts["a"] || counts["c"] < counts["b"] {
+		t.Error("expected the heavier scene to play most", counts)
+	}
+}
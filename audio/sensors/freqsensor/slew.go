@@ -0,0 +1,157 @@
+package freqsensor
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Slewer moves the parameters used for each frame smoothly toward the ones that were set,
+// so changes don't make the visuals jump. Continuous fields follow their targets through a
+// lowpass with a configurable time constant. A crossfade instead moves every continuous
+// field linearly from where it was to its target over a fixed time, and switches discrete
+// fields such as Mode halfway through.
+type Slewer struct {
+	lock sync.Mutex
+	time float64
+
+	infos   []*ParamInfo // the continuous fields
+	current []float64
+	output  *Parameters
+
+	fade        float64 // duration of a crossfade that starts when fadeVersion is reached
+	fadeVersion uint64
+	from        []float64
+	fromDisc    *Parameters
+	elapsed     float64
+	duration    float64
+}
+
+// NewSlewer creates a slewer with a time constant of @seconds.
+func NewSlewer(seconds float64) (*Slewer, error) {
+	s := new(Slewer)
+	for _, pi := range ParamInfos() {
+		if (pi.Type == "Float" || pi.Type == "Int") && len(pi.Enum) == 0 {
+			s.infos = append(s.infos, pi)
+		}
+	}
+	if err := s.SetTime(seconds); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetTime sets the time constant of the slew in seconds. Zero makes changes immediate.
+func (s *Slewer) SetTime(seconds float64) error {
+	if seconds < 0 || math.IsNaN(seconds) {
+		return errors.New("slew time must not be negative")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.time = seconds
+	return nil
+}
+
+// Time returns the time constant of the slew in seconds.
+func (s *Slewer) Time() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.time
+}
+
+// Crossfade makes the target with @version, as numbered by the ParameterStore, fade in over
+// @seconds. The fade starts from the parameters of the last step before it, so it has to
+// be armed before a step can see that version.
+func (s *Slewer) Crossfade(version uint64, seconds float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fade = seconds
+	s.fadeVersion = version
+}
+
+// Fading tells whether a crossfade is in progress.
+func (s *Slewer) Fading() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.from != nil
+}
+
+func (s *Slewer) values(p *Parameters) []float64 {
+	elem := reflect.ValueOf(p).Elem()
+	values := make([]float64, len(s.infos))
+	for i, pi := range s.infos {
+		f := elem.Field(pi.field)
+		if f.Kind() == reflect.Float64 {
+			values[i] = f.Float()
+		} else {
+			values[i] = float64(f.Int())
+		}
+	}
+	return values
+}
+
+// Step advances the slew by @dt seconds toward @target, which has @version, and returns the
+// parameters to use for the frame. @target isn't changed.
+func (s *Slewer) Step(target *Parameters, version uint64, dt float64) *Parameters {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.output == nil {
+		s.current = s.values(target)
+		s.output = target
+		return target
+	}
+
+	if s.fade > 0 && version >= s.fadeVersion {
+		s.from = s.current
+		s.fromDisc = s.output
+		s.elapsed = 0
+		s.duration = s.fade
+		s.fade = 0
+	}
+
+	goal := s.values(target)
+	next := make([]float64, len(goal))
+	out := *target
+
+	switch {
+	case s.from != nil:
+		s.elapsed += dt
+		x := s.elapsed / s.duration
+		if x > 1-1e-9 {
+			// allow for rounding in the sum of the steps
+			x = 1
+		}
+		for i := range next {
+			next[i] = s.from[i] + x*(goal[i]-s.from[i])
+		}
+		if x < 0.5 {
+			// discrete fields switch at the midpoint
+			out = *s.fromDisc
+		}
+		if x >= 1 {
+			s.from, s.fromDisc = nil, nil
+		}
+	case s.time > 0:
+		a := 1 - math.Exp(-dt/s.time)
+		for i := range next {
+			next[i] = s.current[i] + a*(goal[i]-s.current[i])
+		}
+	default:
+		copy(next, goal)
+	}
+
+	elem := reflect.ValueOf(&out).Elem()
+	for i, pi := range s.infos {
+		f := elem.Field(pi.field)
+		if f.Kind() == reflect.Float64 {
+			f.SetFloat(next[i])
+		} else {
+			f.SetInt(int64(math.Floor(next[i] + 0.5)))
+		}
+	}
+	s.current = next
+	s.output = &out
+	return &out
+}
@@ -0,0 +1,83 @@
+package freqsensor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSlewer(t *testing.T) {
+	s, err := NewSlewer(0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := *DefaultParameters
+	s.Step(&a, 0, 0.01)
+
+	b := a
+	b.Gain = a.Gain + 1
+	b.Mode = NormalMode
+	p := s.Step(&b, 1, 0.1)
+	// one time constant gets 63% of the way there
+	if want := a.Gain + 1 - math.Exp(-1); math.Abs(p.Gain-want) > 1e-9 {
+		t.Errorf("expected gain %v, got %v", want, p.Gain)
+	}
+	if p.Mode != NormalMode {
+		t.Error("expected discrete fields to change at once when slewing")
+	}
+
+	// a fade waits for the version it's for
+	s.Crossfade(2, 1)
+	if p = s.Step(&b, 1, 0.1); s.Fading() {
+		t.Error("expected the fade to wait for its version")
+	}
+
+	// crossfade back over a second
+	var mid *Parameters
+	for i := 0; i < 10; i++ {
+		p = s.Step(&a, 2, 0.1)
+		if i == 3 {
+			mid = p
+		}
+	}
+	if mid.Mode != NormalMode || p.Mode != a.Mode {
+		t.Error("expected the mode to switch halfway through the fade", mid.Mode, p.Mode)
+	}
+	if p.Gain != a.Gain || s.Fading() {
+		t.Error("expected the fade to be done, got gain", p.Gain)
+	}
+
+	if err := s.SetTime(-1); err == nil {
+		t.Error("expected a negative time to fail")
+	}
+}
+
+func TestSlewFrames(t *testing.T) {
+	d := newTestSensor(t, nil)
+	if err := d.SetFrameRate(100); err != nil {
+		t.Fatal(err)
+	}
+	runFrames(d, 1, 0)
+	gain := d.Parameters().Load().Gain
+
+	// the crossfade takes 10 frames at 100 frames per second, however fast they come
+	p := *d.Parameters().Load()
+	p.Gain = gain + 1
+	d.Slewer().Crossfade(d.Parameters().Set(&p), 0.1)
+	runFrames(d, 5, 0)
+	if g := d.FrameParameters().Gain; math.Abs(g-(gain+0.5)) > 1e-9 {
+		t.Errorf("expected the gain to be halfway after 5 frames, got %v", g)
+	}
+	runFrames(d, 5, 0)
+	if g := d.FrameParameters().Gain; g != gain+1 {
+		t.Errorf("expected the crossfade to be done after 10 frames, got %v", g)
+	}
+	// frames that don't come from Process step the parameters themselves
+	p.Gain = gain
+	d.Slewer().Crossfade(d.Parameters().Set(&p), 0.1)
+	for i := 0; i < 5; i++ {
+		d.StepParameters()
+	}
+	if g := d.FrameParameters().Gain; math.Abs(g-(gain+0.5)) > 1e-9 {
+		t.Errorf("expected the gain to be halfway after 5 steps, got %v", g)
+	}
+}
@@ -0,0 +1,261 @@
+package freqsensor
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultStreamRate is the most frames per second a Streamer sends to a client that
+// doesn't ask for a rate.
+const DefaultStreamRate = 60
+
+// Stream encodings
+const (
+	JSONEncoding   = "json"
+	BinaryEncoding = "binary"
+)
+
+// Streamer streams the output of a sensor to remote clients over WebSocket or Server-Sent
+// Events. Clients pick what they get with query parameters:
+//
+//	fields  comma separated fields of Drivers, see ParseDriverFields; all by default
+//	rate    the most frames per second to send, up to MaxRate
+//	format  "json", or "binary" for AppendDriversBinary over WebSocket only
+//
+// A client that falls behind skips to the latest frame rather than holding up the others.
+type Streamer struct {
+	// MaxRate caps the rate of every client. It defaults to DefaultStreamRate.
+	MaxRate float64
+	// CheckOrigin decides whether to accept a WebSocket from another origin. By default
+	// only the same origin is accepted.
+	CheckOrigin func(r *http.Request) bool
+
+	lock    sync.Mutex
+	clients map[*streamClient]struct{}
+}
+
+type streamClient struct {
+	frames chan *Drivers
+}
+
+// NewStreamer creates a streamer with no clients.
+func NewStreamer() *Streamer {
+	return &Streamer{
+		MaxRate: DefaultStreamRate,
+		clients: make(map[*streamClient]struct{}),
+	}
+}
+
+// Process passes the frames from @in through, and sends each one to the clients.
+func (s *Streamer) Process(done chan struct{}, in chan *Drivers) chan *Drivers {
+	out := make(chan *Drivers)
+	go func() {
+		defer close(out)
+		for drv := range in {
+			s.broadcast(drv)
+			select {
+			case out <- drv:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (s *Streamer) broadcast(drv *Drivers) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for c := range s.clients {
+		// only the latest frame is kept for a client that hasn't taken the last one
+		select {
+		case <-c.frames:
+		default:
+		}
+		c.frames <- drv
+	}
+}
+
+func (s *Streamer) subscribe() *streamClient {
+	c := &streamClient{frames: make(chan *Drivers, 1)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clients[c] = struct{}{}
+	return c
+}
+
+func (s *Streamer) unsubscribe(c *streamClient) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.clients, c)
+}
+
+// Clients returns how many clients are connected.
+func (s *Streamer) Clients() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.clients)
+}
+
+// streamOptions are the settings a client asks for.
+type streamOptions struct {
+	fields   DriverFields
+	interval time.Duration
+	format   string
+}
+
+func (s *Streamer) options(r *http.Request) (streamOptions, error) {
+	q := r.URL.Query()
+	var opts streamOptions
+	var err error
+	if opts.fields, err = ParseDriverFields(q.Get("fields")); err != nil {
+		return opts, err
+	}
+
+	rate := s.MaxRate
+	if rate <= 0 {
+		rate = DefaultStreamRate
+	}
+	if v := q.Get("rate"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(r > 0) {
+			return opts, fmt.Errorf("rate %q must be a positive number", v)
+		}
+		if r < rate {
+			rate = r
+		}
+	}
+	opts.interval = time.Duration(float64(time.Second) / rate)
+
+	switch opts.format = q.Get("format"); opts.format {
+	case "":
+		opts.format = JSONEncoding
+	case JSONEncoding, BinaryEncoding:
+	default:
+		return opts, fmt.Errorf("unknown format %q", opts.format)
+	}
+	return opts, nil
+}
+
+// ServeHTTP streams frames over a WebSocket if the request asks for one, and as
+// Server-Sent Events otherwise.
+func (s *Streamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.options(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWebSocket(w, r, opts)
+		return
+	}
+	if opts.format != JSONEncoding {
+		http.Error(w, "server-sent events are only sent as json", http.StatusBadRequest)
+		return
+	}
+	s.serveEvents(w, r, opts)
+}
+
+// next waits for the next frame that's due for a client. It returns nil when @stop is
+// closed.
+func (c *streamClient) next(stop <-chan struct{}, last time.Time, opts streamOptions) *Drivers {
+	if wait := time.Until(last.Add(opts.interval)); wait > 0 {
+		// frames that come in the meantime replace each other
+		select {
+		case <-time.After(wait):
+		case <-stop:
+			return nil
+		}
+	}
+	select {
+	case drv := <-c.frames:
+		return drv
+	case <-stop:
+		return nil
+	}
+}
+
+func (s *Streamer) serveEvents(w http.ResponseWriter, r *http.Request, opts streamOptions) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := s.subscribe()
+	defer s.unsubscribe(c)
+	var last time.Time
+	for {
+		drv := c.next(r.Context().Done(), last, opts)
+		if drv == nil {
+			return
+		}
+		last = time.Now()
+		bs, err := MarshalDriversJSON(drv, opts.fields)
+		if err != nil {
+			log.Println("[ERROR] encoding drivers:", err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", drv.Seq, bs); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Streamer) serveWebSocket(w http.ResponseWriter, r *http.Request, opts streamOptions) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.CheckOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied
+		return
+	}
+	defer conn.Close()
+
+	// the client doesn't send anything, but reading notices when it goes away
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := s.subscribe()
+	defer s.unsubscribe(c)
+	var last time.Time
+	var buf []byte
+	for {
+		drv := c.next(stop, last, opts)
+		if drv == nil {
+			return
+		}
+		last = time.Now()
+		if opts.format == BinaryEncoding {
+			buf = AppendDriversBinary(buf[:0], drv, opts.fields)
+			err = conn.WriteMessage(websocket.BinaryMessage, buf)
+		} else {
+			var bs []byte
+			if bs, err = MarshalDriversJSON(drv, opts.fields); err == nil {
+				err = conn.WriteMessage(websocket.TextMessage, bs)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
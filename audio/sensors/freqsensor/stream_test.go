@@ -0,0 +1,178 @@
+package freqsensor
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func testDrivers(seq uint64) *Drivers {
+	return &Drivers{
+		Amplitude:      [][]float64{{1, 2, 3}, {4, 5, 6}},
+		Diff:           []float64{0.5, -0.5, 0.25},
+		Energy:         []float64{1, 1, 1},
+		Bass:           0.75,
+		Bands:          map[string]float64{"sub": 1, "bass": 2},
+		Seq:            seq,
+		SamplePosition: int64(seq) * 1024,
+		Time:           time.Unix(100, 5),
+		ParamVersion:   3,
+	}
+}
+
+func TestDriversBinary(t *testing.T) {
+	drv := testDrivers(7)
+	buf := AppendDriversBinary(nil, drv, AllFields)
+	got, fields, n, err := ReadDriversBinary(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields != AllFields || n != len(buf) {
+		t.Errorf("expected to read all %d bytes of all fields, got %d of %b", len(buf), n, fields)
+	}
+	if got.Seq != 7 || got.SamplePosition != 7*1024 || !got.Time.Equal(drv.Time) ||
+		got.ParamVersion != 3 || got.Bass != 0.75 || got.Bands["bass"] != 2 ||
+		got.Amplitude[1][2] != 6 || got.Diff[1] != -0.5 || len(got.Energy) != 3 {
+		t.Errorf("round trip changed the drivers: %+v", got)
+	}
+
+	fields, _ = ParseDriverFields("bass, bands")
+	small := AppendDriversBinary(nil, drv, fields)
+	if got, _, _, err = ReadDriversBinary(small); err != nil {
+		t.Fatal(err)
+	}
+	if got.Amplitude != nil || got.Diff != nil || got.Bass != 0.75 || len(got.Bands) != 2 {
+		t.Errorf("expected only the bass and bands, got %+v", got)
+	}
+	if _, _, _, err := ReadDriversBinary(small[:len(small)-1]); err == nil {
+		t.Error("expected an error for a truncated frame")
+	}
+	if _, err := ParseDriverFields("amplitude,treble"); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func startStreamer(t *testing.T) (*Streamer, chan *Drivers, *httptest.Server) {
+	s := NewStreamer()
+	done := make(chan struct{})
+	in := make(chan *Drivers)
+	out := s.Process(done, in)
+	go func() {
+		for range out {
+		}
+	}()
+	srv := httptest.NewServer(s)
+	t.Cleanup(func() {
+		srv.Close()
+		close(in)
+		close(done)
+	})
+	return s, in, srv
+}
+
+// waitClients waits until @n clients are connected.
+func waitClients(t *testing.T, s *Streamer, n int) {
+	for i := 0; s.Clients() != n; i++ {
+		if i > 1000 {
+			t.Fatalf("expected %d clients, have %d", n, s.Clients())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	s, in, srv := startStreamer(t)
+
+	res, err := http.Get(srv.URL + "?format=binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected binary events to be refused, got %s", res.Status)
+	}
+
+	res, err = http.Get(srv.URL + "?fields=bass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatal("unexpected content type", ct)
+	}
+	waitClients(t, s, 1)
+	in <- testDrivers(1)
+
+	r := bufio.NewReader(res.Body)
+	var id, data string
+	for data == "" {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimSpace(line[4:])
+		case strings.HasPrefix(line, "data: "):
+			data = line[6:]
+		}
+	}
+	if id != "1" {
+		t.Errorf("expected event id 1, got %q", id)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["bass"] != 0.75 || msg["seq"] != 1.0 {
+		t.Errorf("unexpected event %v", msg)
+	}
+	if _, ok := msg["amplitude"]; ok {
+		t.Error("expected the amplitude to be left out")
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	s, in, srv := startStreamer(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "?format=binary&rate=10"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitClients(t, s, 1)
+
+	in <- testDrivers(1)
+	typ, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv, _, _, err := ReadDriversBinary(msg)
+	if typ != websocket.BinaryMessage || err != nil || drv.Seq != 1 {
+		t.Fatalf("unexpected message %v %v %v", typ, drv, err)
+	}
+
+	// at 10 frames per second the frames in between are skipped
+	start := time.Now()
+	for seq := uint64(2); seq <= 5; seq++ {
+		in <- testDrivers(seq)
+	}
+	if _, msg, err = conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if drv, _, _, _ = ReadDriversBinary(msg); drv.Seq != 5 {
+		t.Errorf("expected the latest frame, got %d", drv.Seq)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("expected the rate to be limited")
+	}
+
+	conn.Close()
+	waitClients(t, s, 0)
+}
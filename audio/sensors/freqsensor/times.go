@@ -0,0 +1,302 @@
+package freqsensor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// defaultFrameRate is the frame rate the default filters are tuned for, and the one that's
+// assumed if the pipeline doesn't give one: blocks of 1024 samples at 44.1kHz.
+const defaultFrameRate = 44100.0 / 1024
+
+// Curves that interpolate the time constants from the lowest to the highest bucket
+const (
+	LinearCurve = iota
+	LogCurve
+)
+
+// TimeConstants are the time constants of the first level of the amp filter for each
+// bucket in milliseconds, for when the amplitude is rising (Attack) and falling (Release).
+type TimeConstants struct {
+	Attack  []float64 `json:"attack" yaml:"attack"`
+	Release []float64 `json:"release" yaml:"release"`
+}
+
+// Validate returns an error if a time constant is negative or not a number.
+func (tc *TimeConstants) Validate() error {
+	for name, times := range map[string][]float64{"attack": tc.Attack, "release": tc.Release} {
+		for i, v := range times {
+			if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("%s time %d: %v is not a time constant", name, i, v)
+			}
+		}
+	}
+	return nil
+}
+
+// timeKey is everything the per bucket coefficients are computed from.
+type timeKey struct {
+	attackLow, attackHigh   float64
+	releaseLow, releaseHigh float64
+	curve                   int
+	tuning                  *tuning // which includes the frame rate
+	buckets                 int
+}
+
+// bucketTimes holds the time constants of each bucket and the coefficients of the first
+// level of the amp filter that they make.
+type bucketTimes struct {
+	key    timeKey
+	active bool
+	times  TimeConstants
+
+	attack  [2][]float64
+	release [2][]float64
+}
+
+// updateTimes recomputes the per bucket coefficients if the parameters or the tuning @t
+// that they depend on changed.
+func (d *FrequencySensor) updateTimes(t *tuning) {
+	p := d.params
+	key := timeKey{
+		attackLow:   p.AttackLow,
+		attackHigh:  p.AttackHigh,
+		releaseLow:  p.ReleaseLow,
+		releaseHigh: p.ReleaseHigh,
+		curve:       p.TimeCurve,
+		tuning:      t,
+		buckets:     d.Buckets,
+	}
+	if d.times != nil && d.times.key == key {
+		return
+	}
+	d.times = newBucketTimes(key, t)
+	d.timesOut.Store(d.times)
+}
+
+func newBucketTimes(key timeKey, t *tuning) *bucketTimes {
+	bt := &bucketTimes{
+		key: key,
+		active: key.attackLow > 0 || key.attackHigh > 0 ||
+			key.releaseLow > 0 || key.releaseHigh > 0 ||
+			len(t.times.Attack) > 0 || len(t.times.Release) > 0,
+	}
+
+	// the filter's own time constant stands in for any that aren't set
+	row := t.filter.gain.RawRowView(0)
+	gain := math.Abs(row[0]) + math.Abs(row[1])
+	own := t.millis(1)
+	if row[0] != 0 {
+		own = t.millis(gain / math.Abs(row[0]))
+	}
+	or := func(v float64) float64 {
+		if v > 0 {
+			return v
+		}
+		return own
+	}
+
+	curve := func(low, high float64, explicit []float64) ([]float64, [2][]float64) {
+		times := make([]float64, key.buckets)
+		var coeffs [2][]float64
+		coeffs[0] = make([]float64, key.buckets)
+		coeffs[1] = make([]float64, key.buckets)
+		for i := range times {
+			x := 0.0
+			if key.buckets > 1 {
+				x = float64(i) / float64(key.buckets-1)
+			}
+			tao := interpolate(or(low), or(high), x, key.curve)
+			if len(explicit) > 0 {
+				if v := resample(explicit, x); v > 0 {
+					tao = v
+				}
+			}
+			times[i] = tao
+
+			// same as SetFilterParams
+			a := 1 / math.Max(t.frames(tao), 1)
+			b := 1 - a
+			coeffs[0][i], coeffs[1][i] = a*gain, b*gain
+			if row[0] < 0 {
+				coeffs[0][i] = -coeffs[0][i]
+			}
+		}
+		return times, coeffs
+	}
+	bt.times.Attack, bt.attack = curve(key.attackLow, key.attackHigh, t.times.Attack)
+	bt.times.Release, bt.release = curve(key.releaseLow, key.releaseHigh, t.times.Release)
+	return bt
+}
+
+// interpolate returns the value a fraction @x of the way from @low to @high on @curve.
+func interpolate(low, high, x float64, curve int) float64 {
+	if curve == LogCurve && low > 0 && high > 0 {
+		return low * math.Pow(high/low, x)
+	}
+	return low + x*(high-low)
+}
+
+// resample returns the value a fraction @x of the way through @values, interpolating
+// linearly between them.
+func resample(values []float64, x float64) float64 {
+	if len(values) == 1 {
+		return values[0]
+	}
+	pos := x * float64(len(values)-1)
+	i := int(pos)
+	if i >= len(values)-1 {
+		return values[len(values)-1]
+	}
+	f := pos - float64(i)
+	return values[i] + f*(values[i+1]-values[i])
+}
+
+// TimeConstants returns the time constants of each bucket in milliseconds as of the last
+// frame.
+func (d *FrequencySensor) TimeConstants() TimeConstants {
+	return d.timesOut.Load().(*bucketTimes).times
+}
+
+// SetTimeConstants overrides the time constants that the parameters' curves give. Each
+// list is spread evenly over the buckets, so it can have one value per bucket or just a
+// few points to interpolate between. Zeros, or an empty list, leave the curve in place.
+func (d *FrequencySensor) SetTimeConstants(tc TimeConstants) error {
+	_, err := d.updateTimeConstants(func(cur *TimeConstants) { *cur = tc })
+	return err
+}
+
+// updateTimeConstants applies @merge to the current time constants under the tuning lock,
+// so that a change to one list doesn't undo a concurrent change to the other, and returns
+// the result.
+func (d *FrequencySensor) updateTimeConstants(merge func(*TimeConstants)) (TimeConstants, error) {
+	var tc TimeConstants
+	err := d.updateTuning(func(t *tuning) error {
+		tc = t.times
+		merge(&tc)
+		if err := tc.Validate(); err != nil {
+			return err
+		}
+		tc = TimeConstants{Attack: copyFloats(tc.Attack), Release: copyFloats(tc.Release)}
+		t.times = tc
+		return nil
+	})
+	return tc, err
+}
+
+// frames converts a time constant in milliseconds to frames.
+func (t *tuning) frames(ms float64) float64 {
+	return ms / 1000 * t.frameRate
+}
+
+// millis converts a time constant in frames to milliseconds.
+func (t *tuning) millis(frames float64) float64 {
+	return frames / t.frameRate * 1000
+}
+
+// FrameRate returns the number of frames per second that the sensor processes.
+func (d *FrequencySensor) FrameRate() float64 {
+	return d.loadTuning().frameRate
+}
+
+// SetFrameRate tells the sensor how many frames per second it processes. The filters and
+// the gain controller are retuned so that their time constants stay the same in seconds.
+func (d *FrequencySensor) SetFrameRate(rate float64) error {
+	if !(rate > 0) || math.IsInf(rate, 0) {
+		return fmt.Errorf("frame rate %v must be positive", rate)
+	}
+	return d.updateTuning(func(t *tuning) error {
+		t.retime(rate)
+		return nil
+	})
+}
+
+// retime rescales the filters and gain controller, which were tuned for the tuning's frame
+// rate, to @rate.
+func (t *tuning) retime(rate float64) {
+	if rate == t.frameRate {
+		return
+	}
+	factor := rate / t.frameRate
+	t.filter = filterValues{
+		gain: retimeChain(t.filter.gain, factor),
+		diff: retimeChain(t.filter.diff, factor),
+	}
+	t.vgc.filter = retimeVGC(t.vgc.filter, factor)
+	t.frameRate = rate
+}
+
+// retimeVGC is like retimeChain for the filter of the gain controller.
+func retimeVGC(filter *mat.VecDense, factor float64) *mat.VecDense {
+	m := mat.NewDense(1, 2, copyFloats(filter.RawVector().Data))
+	return mat.NewVecDense(2, retimeChain(m, factor).RawRowView(0))
+}
+
+// retimeChain returns a copy of the filter chain @m with the time constant of each level
+// multiplied by @factor. Levels that aren't a lowpass in the form that SetFilterParams
+// makes are copied as they are.
+func retimeChain(m *mat.Dense, factor float64) *mat.Dense {
+	out := mat.DenseCopyOf(m)
+	if factor == 1 {
+		return out
+	}
+	for level := 0; level < levels(out); level++ {
+		row := out.RawRowView(level)
+		a, b := row[0], row[1]
+		if a == 0 || b < 0 {
+			continue
+		}
+		gain := math.Abs(a) + b
+		tao := math.Max(gain/math.Abs(a)*factor, 1)
+		row[0], row[1] = gain/tao, gain*(1-1/tao)
+		if a < 0 {
+			row[0] = -row[0]
+		}
+	}
+	return out
+}
+
+// filterTimes returns the time constant of each level of a filter chain in milliseconds.
+// The time constant of a level with negative feedback is negative, and that of a level that
+// ignores its input is zero.
+func (t *tuning) filterTimes(m *mat.Dense) []float64 {
+	times := make([]float64, levels(m))
+	for level := range times {
+		a, b := m.At(level, 0), m.At(level, 1)
+		if a == 0 {
+			// the level only holds its state
+			continue
+		}
+		times[level] = t.millis((math.Abs(a) + math.Abs(b)) / a)
+	}
+	return times
+}
+
+// SetFilterTime is like SetFilterParams, but takes the time constant in milliseconds.
+func (d *FrequencySensor) SetFilterTime(typ string, level int, gain, ms float64) error {
+	if math.IsNaN(ms) || math.IsInf(ms, 0) {
+		return errors.New("time constant must be a number")
+	}
+	return d.SetFilterParams(typ, level, gain, d.loadTuning().frames(ms))
+}
+
+// decay returns how much of the amplitude is kept from one column to the next in
+// AnimateMode.
+func (d *FrequencySensor) decay() float64 {
+	if ms := d.params.Decay; ms > 0 {
+		return 1 - 1/math.Max(d.lastTuning.frames(ms), 1)
+	}
+	// the trail fades over half of the display
+	return 1 - (2.0 / float64(d.Frames))
+}
+
+// bassSmoothing returns the weight of the new value in the lowpass on the bass.
+func (d *FrequencySensor) bassSmoothing() float64 {
+	if ms := d.params.BassSmoothing; ms > 0 {
+		return 1 / math.Max(d.lastTuning.frames(ms), 1)
+	}
+	return .75
+}
@@ -0,0 +1,133 @@
+package freqsensor
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestTimeConstants(t *testing.T) {
+	d := newTestSensor(t, nil)
+	if err := d.SetRawFilter("amp", []float64{0.5, 0.5, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	runFrames(d, 1, 0)
+
+	// the filter's time constant is 2 frames
+	own := 2000 / defaultFrameRate
+	tc := d.TimeConstants()
+	if len(tc.Attack) != 16 || math.Abs(tc.Attack[0]-own) > 1e-9 ||
+		math.Abs(tc.Release[15]-own) > 1e-9 {
+		t.Fatalf("expected the filter's own time constant in every bucket, got %v", tc)
+	}
+
+	d.Parameters().Update(func(p *Parameters) {
+		p.AttackLow, p.AttackHigh = 1, 1
+		p.ReleaseLow, p.ReleaseHigh = 100, 10
+		p.TimeCurve = LogCurve
+	})
+	runFrames(d, 1, 0)
+	tc = d.TimeConstants()
+	if r := tc.Release; r[0] != 100 || math.Abs(r[15]-10) > 1e-9 ||
+		math.Abs(r[5]-100*math.Pow(0.1, 5.0/15)) > 1e-9 {
+		t.Errorf("unexpected release curve %v", r)
+	}
+
+	// with an attack under one frame, the amplitude jumps to the input and then decays
+	// faster in the high buckets. The buckets are scaled differently on the way in, so
+	// compare how much of the jump is left.
+	runFrames(d, 1, 1)
+	amp := append([]float64(nil), d.filterValues.gain.RawRowView(0)...)
+	runFrames(d, 20, 0)
+	low, high := d.filterValues.gain.At(0, 0), d.filterValues.gain.At(0, 15)
+	if amp[0] <= 0 || amp[15] <= 0 || low/amp[0] <= high/amp[15] {
+		t.Errorf("expected the low bucket to decay slower, got %v and %v",
+			low/amp[0], high/amp[15])
+	}
+
+	res := d.Query(`mutation { timeConstants(release: [0, 50]) { release } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	runFrames(d, 1, 0)
+	tc = d.TimeConstants()
+	if r := tc.Release; r[0] != 100 || r[15] != 50 {
+		t.Errorf("expected the zero to leave the curve in place, got %v", r)
+	}
+	if p := d.Preset("x"); p.Filter.Times == nil || len(p.Filter.Times.Release) != 2 {
+		t.Error("expected the preset to keep the time constants")
+	}
+
+	res = d.Query(`mutation { timeConstants(attack: [-1]) { attack } }`, nil)
+	if len(res.Errors) == 0 {
+		t.Error("expected an error for a negative time constant")
+	}
+
+	// mutations of the two lists at once keep both
+	var wg sync.WaitGroup
+	for _, q := range []string{
+		`mutation { timeConstants(attack: [5]) { attack } }`,
+		`mutation { timeConstants(release: [60]) { release } }`,
+	} {
+		wg.Add(1)
+		go func(q string) {
+			defer wg.Done()
+			d.Query(q, nil)
+		}(q)
+	}
+	wg.Wait()
+	if tc := d.loadTuning().times; len(tc.Attack) != 1 || len(tc.Release) != 1 {
+		t.Errorf("expected both mutations to stick, got %v", tc)
+	}
+}
+
+func TestFrameRate(t *testing.T) {
+	d := newTestSensor(t, nil)
+	if d.FrameRate() != defaultFrameRate {
+		t.Fatal("expected the default frame rate, got", d.FrameRate())
+	}
+	if err := d.SetFilterTime("amp", 0, 1, 100); err != nil {
+		t.Fatal(err)
+	}
+	before := d.loadTuning()
+	ms := before.filterTimes(before.filter.gain)[0]
+	if math.Abs(ms-100) > 1e-9 {
+		t.Fatalf("expected a time constant of 100ms, got %v", ms)
+	}
+	p := d.Preset("x")
+
+	// twice the frame rate needs twice the frames for the same time
+	if err := d.SetFrameRate(2 * defaultFrameRate); err != nil {
+		t.Fatal(err)
+	}
+	after := d.loadTuning()
+	if ms := after.filterTimes(after.filter.gain)[0]; math.Abs(ms-100) > 1e-9 {
+		t.Errorf("expected the time constant to stay at 100ms, got %v", ms)
+	}
+	if a := after.filter.gain.At(0, 0); math.Abs(a-before.filter.gain.At(0, 0)/2) > 1e-9 {
+		t.Errorf("expected the coefficient to halve, got %v", a)
+	}
+
+	// a preset saved at the old rate is retimed
+	if err := d.SetRawFilter("amp", []float64{1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ApplyPreset(p); err != nil {
+		t.Fatal(err)
+	}
+	tn := d.loadTuning()
+	if ms := tn.filterTimes(tn.filter.gain)[0]; math.Abs(ms-100) > 1e-9 {
+		t.Errorf("expected the preset's time constant of 100ms, got %v", ms)
+	}
+
+	// Process works the rate out from the spectrum
+	d = newTestSensor(t, nil)
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64, 1)
+	in <- make([]float64, 512)
+	d.Process(done, in)
+	if rate := d.FrameRate(); rate != 44100.0/1024 {
+		t.Errorf("expected a frame rate of %v, got %v", 44100.0/1024, rate)
+	}
+}
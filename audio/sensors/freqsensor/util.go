@@ -1,6 +1,7 @@
 package freqsensor
 
 import (
+	"fmt"
 	"math"
 
 	"gonum.org/v1/gonum/mat"
@@ -21,11 +22,36 @@ func newDrivers(rows int, columns int) drivers {
 	}
 }
 
+// filterValues holds a matrix for each of the amp and diff filter chains. For the
+// coefficients, there's a row of two for each level; for the state, a row of one value per
+// bucket for each level.
 type filterValues struct {
 	gain *mat.Dense
 	diff *mat.Dense
 }
 
+// levels returns the number of levels in a filter chain.
+func levels(m *mat.Dense) int {
+	r, _ := m.Dims()
+	return r
+}
+
+// newFilterChain makes the coefficients of a filter chain from pairs in @raw.
+func newFilterChain(raw []float64) (*mat.Dense, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("a filter chain needs two coefficients per level, got %d", len(raw))
+	}
+	return mat.NewDense(len(raw)/2, 2, copyFloats(raw)), nil
+}
+
+// newFilterValues makes the state for the filter chains @params.
+func newFilterValues(params filterValues, buckets int) filterValues {
+	return filterValues{
+		gain: mat.NewDense(levels(params.gain), buckets, nil),
+		diff: mat.NewDense(levels(params.diff), buckets, nil),
+	}
+}
+
 type variableGainController struct {
 	filterParams *mat.VecDense
 	frame        *mat.VecDense
@@ -34,6 +60,12 @@ type variableGainController struct {
 	size         int
 	kp           float64
 	kd           float64
+	target       float64
+	minGain      float64
+	maxGain      float64
+	curve        func(level, target float64) float64
+	frozen       bool
+	resets       int
 }
 
 func newVariableGainController(size int, params []float64) *variableGainController {
@@ -50,6 +82,47 @@ func newVariableGainController(size int, params []float64) *variableGainControll
 		size:         size,
 		kp:           1,
 		kd:           16,
+		target:       1,
+		maxGain:      10000,
+		curve:        vgcCurves[QuadraticVGCCurve],
+	}
+}
+
+// vgcParams are the settings of a variableGainController.
+type vgcParams struct {
+	filter  *mat.VecDense
+	kp      float64
+	kd      float64
+	target  float64
+	minGain float64
+	maxGain float64
+	curve   string
+	frozen  bool
+	// resets counts the requests to reset the gains
+	resets int
+}
+
+// configure changes the controller's settings. The filter is only read, so it may be shared.
+func (v *variableGainController) configure(p vgcParams) {
+	v.filterParams = p.filter
+	v.kp = p.kp
+	v.kd = p.kd
+	v.target = p.target
+	v.minGain = p.minGain
+	v.maxGain = p.maxGain
+	v.curve = vgcCurves[p.curve]
+	v.frozen = p.frozen
+	if p.resets != v.resets {
+		v.reset()
+		v.resets = p.resets
+	}
+}
+
+// reset starts the gains over at 1.
+func (v *variableGainController) reset() {
+	for i := range v.gain {
+		v.gain[i] = 1
+		v.err[i] = 0
 	}
 }
 
@@ -60,26 +133,62 @@ func (v *variableGainController) apply(input []float64) {
 	var e = make([]float64, v.size)
 
 	for i := range e {
-		//e[i] = sigmoidCurve(1 - v.frame.AtVec(i))
-		e[i] = quadraticCurve(1 - v.frame.AtVec(i))
-		//e[i] = logCurve(.0000001 + v.frame.AtVec(i))
+		e[i] = v.curve(v.frame.AtVec(i), v.target)
 	}
 
 	for i := range e {
-		u := v.kp*e[i] + v.kd*(e[i]-v.err[i])
-		v.gain[i] += u
-		if v.gain[i] > 10000 {
-			v.gain[i] = 10000
-		} else if v.gain[i] < 0 {
-			v.gain[i] = 0
+		if !v.frozen {
+			u := v.kp*e[i] + v.kd*(e[i]-v.err[i])
+			v.gain[i] += u
+			if v.gain[i] > v.maxGain {
+				v.gain[i] = v.maxGain
+			} else if v.gain[i] < v.minGain {
+				v.gain[i] = v.minGain
+			}
 		}
-		// bs, _ := json.Marshal(v.gain)
-		// fmt.Println(string(bs))
-		//fmt.Println(v.frame.AtVec(i), u, v.gain[i])
+		// the error keeps up while frozen so the gains don't kick when they're released
 		v.err[i] = e[i]
 	}
 }
 
+// beatDetector finds onsets in the bass, where it jumps well above its recent average.
+type beatDetector struct {
+	avg  float64
+	hold int
+}
+
+func (b *beatDetector) push(bass float64) bool {
+	beat := b.hold == 0 && bass > 0.1 && bass > 1.5*b.avg
+	b.avg = .95*b.avg + .05*bass
+	if beat {
+		// ignore the rest of the same hit
+		b.hold = 8
+	} else if b.hold > 0 {
+		b.hold--
+	}
+	return beat
+}
+
+// Error curves of the variable gain controller
+const (
+	QuadraticVGCCurve = "quadratic"
+	SigmoidVGCCurve   = "sigmoid"
+	LogVGCCurve       = "log"
+)
+
+// vgcCurves map the level of a bucket to the error that the gain controller corrects.
+var vgcCurves = map[string]func(level, target float64) float64{
+	QuadraticVGCCurve: func(level, target float64) float64 {
+		return quadraticCurve(target - level)
+	},
+	SigmoidVGCCurve: func(level, target float64) float64 {
+		return sigmoidCurve(target - level)
+	},
+	LogVGCCurve: func(level, target float64) float64 {
+		return logCurve(.0000001 + level/target)
+	},
+}
+
 func Sigmoid(x float64) float64 {
 	return 1 / (1 + math.Exp(-x))
 }
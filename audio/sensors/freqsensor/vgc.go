@@ -0,0 +1,285 @@
+package freqsensor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"gonum.org/v1/gonum/mat"
+)
+
+// vgcHistorySize is how many frames of gains the sensor keeps for the vgcHistory query.
+const vgcHistorySize = 512
+
+// params checks the settings and converts them for the controller. Settings that are
+// missing, as in presets saved before they existed, get their defaults.
+func (v *VGCPreset) params() (vgcParams, error) {
+	p := vgcParams{
+		kp:      v.Kp,
+		kd:      v.Kd,
+		target:  v.Target,
+		minGain: v.MinGain,
+		maxGain: v.MaxGain,
+		curve:   v.Curve,
+	}
+	if len(v.Filter) != 2 {
+		return p, errors.New("vgc filter must have 2 coefficients")
+	}
+	p.filter = mat.NewVecDense(2, copyFloats(v.Filter))
+	if p.target == 0 {
+		p.target = 1
+	}
+	if p.maxGain == 0 {
+		p.maxGain = 10000
+	}
+	if p.curve == "" {
+		p.curve = QuadraticVGCCurve
+	}
+	for _, x := range []float64{v.Filter[0], v.Filter[1], p.kp, p.kd, p.target, p.minGain, p.maxGain} {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return p, errors.New("vgc settings must be numbers")
+		}
+	}
+	if p.target < 0 {
+		return p, errors.New("vgc target must be positive")
+	}
+	if p.minGain < 0 || p.maxGain < p.minGain {
+		return p, fmt.Errorf("vgc gain limits [%v, %v] are out of order", p.minGain, p.maxGain)
+	}
+	if _, ok := vgcCurves[p.curve]; !ok {
+		return p, fmt.Errorf("unknown vgc curve %q", p.curve)
+	}
+	return p, nil
+}
+
+func (p vgcParams) preset() *VGCPreset {
+	return &VGCPreset{
+		Filter:  copyFloats(p.filter.RawVector().Data),
+		Kp:      p.kp,
+		Kd:      p.kd,
+		Target:  p.target,
+		MinGain: p.minGain,
+		MaxGain: p.maxGain,
+		Curve:   p.curve,
+	}
+}
+
+// VGC returns the settings of the variable gain controller.
+func (d *FrequencySensor) VGC() *VGCPreset {
+	return d.loadTuning().vgc.preset()
+}
+
+// SetVGC changes the settings of the variable gain controller. Whether it's frozen isn't
+// affected.
+func (d *FrequencySensor) SetVGC(v *VGCPreset) error {
+	p, err := v.params()
+	if err != nil {
+		return err
+	}
+	return d.updateTuning(func(t *tuning) error {
+		p.frozen, p.resets = t.vgc.frozen, t.vgc.resets
+		t.vgc = p
+		return nil
+	})
+}
+
+// FreezeVGC stops the gains from changing until it's called again with false.
+func (d *FrequencySensor) FreezeVGC(freeze bool) {
+	d.updateTuning(func(t *tuning) error {
+		t.vgc.frozen = freeze
+		return nil
+	})
+}
+
+// VGCFrozen tells whether the gains are frozen.
+func (d *FrequencySensor) VGCFrozen() bool {
+	return d.loadTuning().vgc.frozen
+}
+
+// ResetVGC starts the gains over at 1 on the next frame.
+func (d *FrequencySensor) ResetVGC() {
+	d.updateTuning(func(t *tuning) error {
+		t.vgc.resets++
+		return nil
+	})
+}
+
+// GainSample is the gain of each bucket in a frame.
+type GainSample struct {
+	Frame int       `json:"frame"`
+	Gain  []float64 `json:"gain"`
+}
+
+// gainHistory keeps the gains of the most recent frames.
+type gainHistory struct {
+	lock    sync.Mutex
+	samples []GainSample
+	next    int
+}
+
+func (h *gainHistory) push(frame int, gain []float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s := GainSample{Frame: frame, Gain: copyFloats(gain)}
+	if len(h.samples) < vgcHistorySize {
+		h.samples = append(h.samples, s)
+		return
+	}
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % vgcHistorySize
+}
+
+// last returns up to @n of the most recent samples, oldest first.
+func (h *gainHistory) last(n int) []GainSample {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if n <= 0 || n > len(h.samples) {
+		n = len(h.samples)
+	}
+	out := make([]GainSample, 0, n)
+	for i := len(h.samples) - n; i < len(h.samples); i++ {
+		out = append(out, h.samples[(h.next+i)%len(h.samples)])
+	}
+	return out
+}
+
+// VGCHistory returns the gains of up to @n of the most recent frames, oldest first. If @n
+// isn't positive, all that are kept are returned.
+func (d *FrequencySensor) VGCHistory(n int) []GainSample {
+	return d.gainHistory.last(n)
+}
+
+// vgcGraphql returns the graphql query and mutation fields of the gain controller.
+func (d *FrequencySensor) vgcGraphql() (graphql.Fields, graphql.Fields) {
+	vgcType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "VGCType",
+			Fields: graphql.Fields{
+				"target":  &graphql.Field{Type: graphql.Float},
+				"kp":      &graphql.Field{Type: graphql.Float},
+				"kd":      &graphql.Field{Type: graphql.Float},
+				"filter":  &graphql.Field{Type: graphql.NewList(graphql.Float)},
+				"minGain": &graphql.Field{Type: graphql.Float},
+				"maxGain": &graphql.Field{Type: graphql.Float},
+				"curve":   &graphql.Field{Type: graphql.String},
+				"smoothing": &graphql.Field{
+					Type:        graphql.Float,
+					Description: "time constant in ms of the lowpass on the level the gains follow",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						a := p.Source.(*VGCPreset).Filter[0]
+						if a == 0 {
+							return nil, nil
+						}
+						return d.loadTuning().millis(1 / math.Abs(a)), nil
+					},
+				},
+				"frozen": &graphql.Field{
+					Type: graphql.Boolean,
+					Resolve: func(graphql.ResolveParams) (interface{}, error) {
+						return d.VGCFrozen(), nil
+					},
+				},
+				"gain": &graphql.Field{
+					Type:        graphql.NewList(graphql.Float),
+					Description: "the gain of each bucket in the last frame",
+					Resolve: func(graphql.ResolveParams) (interface{}, error) {
+						if h := d.VGCHistory(1); len(h) > 0 {
+							return h[0].Gain, nil
+						}
+						return []float64{}, nil
+					},
+				},
+			},
+		},
+	)
+	gainSampleType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "GainSampleType",
+			Fields: graphql.Fields{
+				"frame": &graphql.Field{Type: graphql.Int},
+				"gain":  &graphql.Field{Type: graphql.NewList(graphql.Float)},
+			},
+		},
+	)
+
+	query := graphql.Fields{
+		"vgc": &graphql.Field{
+			Type: vgcType,
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return d.VGC(), nil
+			},
+		},
+		"vgcHistory": &graphql.Field{
+			Type: graphql.NewList(gainSampleType),
+			Args: graphql.FieldConfigArgument{
+				"last": &graphql.ArgumentConfig{
+					Type:        graphql.Int,
+					Description: "how many of the most recent frames to return",
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				n, _ := p.Args["last"].(int)
+				return d.VGCHistory(n), nil
+			},
+		},
+	}
+
+	mut := graphql.Fields{
+		"vgc": &graphql.Field{
+			Type: vgcType,
+			Args: graphql.FieldConfigArgument{
+				"target":    &graphql.ArgumentConfig{Type: graphql.Float},
+				"kp":        &graphql.ArgumentConfig{Type: graphql.Float},
+				"kd":        &graphql.ArgumentConfig{Type: graphql.Float},
+				"filter":    &graphql.ArgumentConfig{Type: graphql.NewList(graphql.Float)},
+				"smoothing": &graphql.ArgumentConfig{Type: graphql.Float},
+				"minGain":   &graphql.ArgumentConfig{Type: graphql.Float},
+				"maxGain":   &graphql.ArgumentConfig{Type: graphql.Float},
+				"curve":     &graphql.ArgumentConfig{Type: graphql.String},
+				"frozen":    &graphql.ArgumentConfig{Type: graphql.Boolean},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				v := d.VGC()
+				args := make(map[string]interface{})
+				for k, a := range p.Args {
+					if k != "smoothing" && k != "frozen" {
+						args[k] = a
+					}
+				}
+				// overlay the arguments on the current settings
+				bs, err := json.Marshal(args)
+				if err != nil {
+					return nil, err
+				}
+				if err := json.Unmarshal(bs, v); err != nil {
+					return nil, err
+				}
+				if s, ok := p.Args["smoothing"]; ok {
+					tao := d.loadTuning().frames(s.(float64))
+					if !(tao >= 1) {
+						return nil, errors.New("vgc smoothing must be at least one frame")
+					}
+					v.Filter = []float64{1 / tao, 1 - 1/tao}
+				}
+				if err := d.SetVGC(v); err != nil {
+					return nil, err
+				}
+				if f, ok := p.Args["frozen"]; ok {
+					d.FreezeVGC(f.(bool))
+				}
+				return d.VGC(), nil
+			},
+		},
+		"resetVGC": &graphql.Field{
+			Type: graphql.Boolean,
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				d.ResetVGC()
+				return true, nil
+			},
+		},
+	}
+	return query, mut
+}
@@ -0,0 +1,135 @@
+package freqsensor
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/peragwin/vuzicgo/audio/fft"
+)
+
+func TestVGCSettings(t *testing.T) {
+	d := newTestSensor(t, nil)
+	v := d.VGC()
+	if v.Target != 1 || v.Kp != 1 || v.Kd != 16 || v.Curve != QuadraticVGCCurve {
+		t.Fatalf("unexpected default settings %+v", v)
+	}
+
+	if err := d.SetFrameRate(100); err != nil {
+		t.Fatal(err)
+	}
+	res := d.Query(`mutation { vgc(target: 2, smoothing: 100, maxGain: 4, curve: "sigmoid") {
+		target kp smoothing filter maxGain curve } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	v = d.VGC()
+	if v.Target != 2 || v.Kp != 1 || v.MaxGain != 4 || v.Curve != SigmoidVGCCurve ||
+		math.Abs(v.Filter[0]-0.1) > 1e-9 || math.Abs(v.Filter[1]-0.9) > 1e-9 {
+		t.Errorf("unexpected settings %+v", v)
+	}
+
+	for _, q := range []string{
+		`mutation { vgc(curve: "cubic") { curve } }`,
+		`mutation { vgc(minGain: 5, maxGain: 1) { curve } }`,
+		`mutation { vgc(smoothing: 5) { curve } }`,
+	} {
+		if res := d.Query(q, nil); len(res.Errors) == 0 {
+			t.Errorf("expected an error for %s", q)
+		}
+	}
+
+	// silence drives the gains up to the limit
+	runFrames(d, 50, 0)
+	h := d.VGCHistory(0)
+	if len(h) != 50 || h[49].Frame != 49 || h[49].Gain[0] != 4 {
+		t.Fatalf("unexpected history %v", h[len(h)-1])
+	}
+
+	d.FreezeVGC(true)
+	d.ResetVGC()
+	runFrames(d, 10, 5)
+	for _, g := range d.VGCHistory(1)[0].Gain {
+		if g != 1 {
+			t.Fatalf("expected frozen gains to stay reset, got %v", g)
+		}
+	}
+	res = d.Query(`{ vgc { frozen gain } vgcHistory(last: 3) { frame } }`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	data := res.Data.(map[string]interface{})
+	if !data["vgc"].(map[string]interface{})["frozen"].(bool) ||
+		len(data["vgcHistory"].([]interface{})) != 3 {
+		t.Errorf("unexpected query result %v", data)
+	}
+}
+
+func TestVGCHistoryWraps(t *testing.T) {
+	var h gainHistory
+	for i := 0; i < vgcHistorySize+10; i++ {
+		h.push(i, []float64{float64(i)})
+	}
+	last := h.last(3)
+	if len(last) != 3 || last[0].Frame != vgcHistorySize+7 || last[2].Frame != vgcHistorySize+9 {
+		t.Errorf("unexpected samples %v", last)
+	}
+	if all := h.last(0); len(all) != vgcHistorySize || all[0].Frame != 10 {
+		t.Errorf("expected the oldest %d samples to be dropped", 10)
+	}
+}
+
+// roomNoise runs @frames spectra of steady noise through a sensor, gated by @gate if it's
+// given, and returns the mean amplitude after the gain has settled.
+func roomNoise(t *testing.T, gate *fft.NoiseGateProcessor, frames int) float64 {
+	d := newTestSensor(t, nil)
+	rnd := rand.New(rand.NewSource(1))
+	spectrum := func() []float64 {
+		// the power in each bin of noise is exponentially distributed
+		x := make([]float64, 512)
+		for i := range x {
+			x[i] = 1e-4 * rnd.ExpFloat64()
+		}
+		if gate != nil {
+			x = gate.Gate(x)
+		}
+		return x
+	}
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64, 1)
+	defer close(in)
+	in <- spectrum()
+	out := d.Process(done, in)
+
+	var sum float64
+	var n int
+	for i := 0; i < frames; i++ {
+		in <- spectrum()
+		drv := <-out
+		if i < frames/2 {
+			continue
+		}
+		for _, row := range drv.Amplitude {
+			for _, v := range row {
+				sum += math.Abs(v)
+				n++
+			}
+		}
+	}
+	return sum / float64(n)
+}
+
+func TestVGCGatedNoise(t *testing.T) {
+	// the gain controller raises room noise to fill the display
+	if a := roomNoise(t, nil, 3000); a < 0.05 {
+		t.Fatal("expected noise to be raised without the gate, got a mean amplitude of", a)
+	}
+	gate, err := fft.NewNoiseGateProcessor(fft.DefaultNoiseGateParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := roomNoise(t, gate, 3000); a > 0.001 {
+		t.Error("expected the gate to keep noise off the display, got a mean amplitude of", a)
+	}
+}
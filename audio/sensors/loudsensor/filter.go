@@ -0,0 +1,121 @@
+package loudsensor
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// biquad is a second order IIR filter section in transposed direct form II.
+type biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+
+	z1, z2 float64
+}
+
+func (f *biquad) apply(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// response returns the complex frequency response of the section at @freq.
+func (f *biquad) response(freq, sampleRate float64) complex128 {
+	z := cmplx.Exp(complex(0, -2*math.Pi*freq/sampleRate))
+	num := complex(f.b0, 0) + complex(f.b1, 0)*z + complex(f.b2, 0)*z*z
+	den := 1 + complex(f.a1, 0)*z + complex(f.a2, 0)*z*z
+	return num / den
+}
+
+// cascade is a chain of biquad sections.
+type cascade []*biquad
+
+func (c cascade) apply(x float64) float64 {
+	for _, f := range c {
+		x = f.apply(x)
+	}
+	return x
+}
+
+func (c cascade) response(freq, sampleRate float64) complex128 {
+	h := complex(1, 0)
+	for _, f := range c {
+		h *= f.response(freq, sampleRate)
+	}
+	return h
+}
+
+// newKWeighting creates the two stage K-weighting pre-filter from ITU-R BS.1770 for any
+// sample rate: a high shelf modelling the acoustic effect of the head followed by the
+// RLB high pass.
+func newKWeighting(sampleRate float64) cascade {
+	const (
+		shelfFreq = 1681.974450955533
+		shelfGain = 3.999843853973347
+		shelfQ    = 0.7071752369554196
+
+		highpassFreq = 38.13547087602444
+		highpassQ    = 0.5003270373238773
+	)
+
+	k := math.Tan(math.Pi * shelfFreq / sampleRate)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := &biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	k = math.Tan(math.Pi * highpassFreq / sampleRate)
+	a0 = 1 + k/highpassQ + k*k
+	highpass := &biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highpassQ + k*k) / a0,
+	}
+
+	return cascade{shelf, highpass}
+}
+
+// newAWeighting creates an A-weighting filter (IEC 61672) by applying the bilinear
+// transform to the analog prototype, normalized to unity gain at 1 kHz.
+func newAWeighting(sampleRate float64) cascade {
+	w1 := 2 * math.Pi * 20.598997
+	w2 := 2 * math.Pi * 107.65265
+	w3 := 2 * math.Pi * 737.86223
+	w4 := 2 * math.Pi * 12194.217
+
+	c := cascade{
+		bilinear(sampleRate, 1, 0, 0, 1, 2*w1, w1*w1),
+		bilinear(sampleRate, 1, 0, 0, 1, w2+w3, w2*w3),
+		bilinear(sampleRate, 0, 0, w4*w4, 1, 2*w4, w4*w4),
+	}
+
+	g := 1 / cmplx.Abs(c.response(1000, sampleRate))
+	c[0].b0 *= g
+	c[0].b1 *= g
+	c[0].b2 *= g
+	return c
+}
+
+// bilinear maps the analog section (B0 s² + B1 s + B2) / (A0 s² + A1 s + A2) to a
+// digital biquad.
+func bilinear(sampleRate, B0, B1, B2, A0, A1, A2 float64) *biquad {
+	c := 2 * sampleRate
+	c2 := c * c
+	a0 := A0*c2 + A1*c + A2
+	return &biquad{
+		b0: (B0*c2 + B1*c + B2) / a0,
+		b1: 2 * (B2 - B0*c2) / a0,
+		b2: (B0*c2 - B1*c + B2) / a0,
+		a1: 2 * (A2 - A0*c2) / a0,
+		a2: (A0*c2 - A1*c + A2) / a0,
+	}
+}
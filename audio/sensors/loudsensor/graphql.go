@@ -0,0 +1,43 @@
+package loudsensor
+
+import (
+	"github.com/graphql-go/graphql"
+)
+
+var levelsType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "LoudnessType",
+		Fields: graphql.Fields{
+			"momentary":  &graphql.Field{Type: graphql.Float},
+			"shortTerm":  &graphql.Field{Type: graphql.Float},
+			"integrated": &graphql.Field{Type: graphql.Float},
+			"truePeak":   &graphql.Field{Type: graphql.Float},
+			"aWeighted":  &graphql.Field{Type: graphql.Float},
+		},
+	},
+)
+
+// QueryFields publishes the current levels as "loudness".
+func (l *LoudnessSensor) QueryFields() graphql.Fields {
+	return graphql.Fields{
+		"loudness": &graphql.Field{
+			Type: levelsType,
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return l.Levels(), nil
+			},
+		},
+	}
+}
+
+// MutationFields adds "resetLoudness", which restarts the integrated measurement.
+func (l *LoudnessSensor) MutationFields() graphql.Fields {
+	return graphql.Fields{
+		"resetLoudness": &graphql.Field{
+			Type: levelsType,
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				l.Reset()
+				return l.Levels(), nil
+			},
+		},
+	}
+}
@@ -0,0 +1,272 @@
+package loudsensor
+
+import (
+	"math"
+	"sync"
+)
+
+// MinLevel is the floor reported for any level in silence, in dB.
+const MinLevel = -120.0
+
+const (
+	subBlockDuration = 0.1 // seconds
+	momentaryBlocks  = 4   // 400ms
+	shortTermBlocks  = 30  // 3s
+
+	absoluteGate = -70.0 // LUFS
+	relativeGate = -10.0 // LU
+
+	// the integrated loudness histogram spans [histMin, histMax) LUFS in histStep steps
+	histMin  = absoluteGate
+	histMax  = 10.0
+	histStep = 0.1
+
+	// time constant of the A-weighted level, equivalent to a sound level meter's
+	// "fast" setting
+	aWeightingTao = 0.125 // seconds
+)
+
+// Levels is the output of a LoudnessSensor.
+type Levels struct {
+	// Momentary is the K-weighted loudness over the last 400ms in LUFS.
+	Momentary float64 `json:"momentary"`
+	// ShortTerm is the K-weighted loudness over the last 3s in LUFS.
+	ShortTerm float64 `json:"shortTerm"`
+	// Integrated is the gated loudness since the last reset in LUFS, per EBU R128.
+	Integrated float64 `json:"integrated"`
+	// TruePeak is the largest inter-sample peak since the last reset in dBTP.
+	TruePeak float64 `json:"truePeak"`
+	// AWeighted is the A-weighted level with fast time weighting, in dB relative to a
+	// full scale sine.
+	AWeighted float64 `json:"aWeighted"`
+}
+
+type histBin struct {
+	count  int
+	energy float64
+}
+
+// LoudnessSensor meters the loudness of a mono time domain signal.
+type LoudnessSensor struct {
+	SampleRate float64
+
+	kWeighting cascade
+	aWeighting cascade
+	truePeak   *truePeak
+
+	subBlockSize int
+	subBlockPos  int
+	subBlockSum  float64
+	// blocks is a ring of the mean square of the last shortTermBlocks sub-blocks
+	blocks    [shortTermBlocks]float64
+	blockPos  int
+	numBlocks int
+
+	aAlpha  float64
+	aEnergy float64
+
+	lock      sync.Mutex
+	levels    Levels
+	peak      float64
+	histogram []histBin
+}
+
+// NewLoudnessSensor creates a new LoudnessSensor for a signal at @sampleRate.
+func NewLoudnessSensor(sampleRate float64) *LoudnessSensor {
+	return &LoudnessSensor{
+		SampleRate:   sampleRate,
+		kWeighting:   newKWeighting(sampleRate),
+		aWeighting:   newAWeighting(sampleRate),
+		truePeak:     newTruePeak(),
+		subBlockSize: int(math.Round(subBlockDuration * sampleRate)),
+		aAlpha:       math.Exp(-1 / (aWeightingTao * sampleRate)),
+		levels:       silentLevels(),
+		histogram:    make([]histBin, int((histMax-histMin)/histStep)),
+	}
+}
+
+// Process kicks off a goroutine to meter incoming @in frames and returns the output
+// channel. Frames are not modified.
+func (l *LoudnessSensor) Process(done chan struct{}, in chan []float64) chan *Levels {
+	out := make(chan *Levels)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			x := <-in
+			if x == nil {
+				return
+			}
+			out <- l.process(x)
+		}
+	}()
+
+	return out
+}
+
+// Levels returns the most recent levels.
+func (l *LoudnessSensor) Levels() *Levels {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	lv := l.levels
+	return &lv
+}
+
+// Reset starts a new measurement of the integrated loudness and true peak.
+func (l *LoudnessSensor) Reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i := range l.histogram {
+		l.histogram[i] = histBin{}
+	}
+	l.peak = 0
+	l.levels.Integrated = MinLevel
+	l.levels.TruePeak = MinLevel
+}
+
+// Gain returns the linear gain that would bring the short-term loudness to @target LUFS.
+// A Normalizer applies it to the input of other sensors.
+func (l *LoudnessSensor) Gain(target float64) float64 {
+	st := l.Levels().ShortTerm
+	if st <= MinLevel {
+		return 1
+	}
+	return math.Pow(10, (target-st)/20)
+}
+
+func (l *LoudnessSensor) process(frame []float64) *Levels {
+	var peak float64
+	for _, x := range frame {
+		peak = math.Max(peak, l.truePeak.apply(x))
+
+		a := l.aWeighting.apply(x)
+		l.aEnergy = l.aAlpha*l.aEnergy + (1-l.aAlpha)*a*a
+
+		k := l.kWeighting.apply(x)
+		l.subBlockSum += k * k
+		l.subBlockPos++
+		if l.subBlockPos == l.subBlockSize {
+			l.pushBlock(l.subBlockSum / float64(l.subBlockSize))
+			l.subBlockPos = 0
+			l.subBlockSum = 0
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if peak > l.peak {
+		l.peak = peak
+	}
+	l.levels.TruePeak = decibels(l.peak)
+	// a full scale sine has a mean square of 1/2
+	l.levels.AWeighted = decibels(math.Sqrt(2 * l.aEnergy))
+	l.levels.Momentary = loudness(l.meanSquare(momentaryBlocks))
+	l.levels.ShortTerm = loudness(l.meanSquare(shortTermBlocks))
+	l.levels.Integrated = l.integrated()
+
+	lv := l.levels
+	return &lv
+}
+
+// pushBlock adds the mean square of a 100ms sub-block. Once there are enough, each
+// sub-block completes a 400ms gating block that overlaps the previous one by 75%.
+func (l *LoudnessSensor) pushBlock(ms float64) {
+	l.blocks[l.blockPos] = ms
+	l.blockPos = (l.blockPos + 1) % shortTermBlocks
+	if l.numBlocks < shortTermBlocks {
+		l.numBlocks++
+	}
+	if l.numBlocks < momentaryBlocks {
+		return
+	}
+
+	block := l.meanSquare(momentaryBlocks)
+	lk := loudness(block)
+	if lk < absoluteGate {
+		return
+	}
+	i := int((lk - histMin) / histStep)
+	if i >= len(l.histogram) {
+		i = len(l.histogram) - 1
+	}
+
+	l.lock.Lock()
+	l.histogram[i].count++
+	l.histogram[i].energy += block
+	l.lock.Unlock()
+}
+
+// meanSquare averages the last @n sub-blocks, or as many as are available.
+func (l *LoudnessSensor) meanSquare(n int) float64 {
+	if n > l.numBlocks {
+		n = l.numBlocks
+	}
+	if n == 0 {
+		return 0
+	}
+	var sum float64
+	for i := 1; i <= n; i++ {
+		sum += l.blocks[(l.blockPos-i+shortTermBlocks)%shortTermBlocks]
+	}
+	return sum / float64(n)
+}
+
+// integrated applies the relative gate to the blocks that passed the absolute gate. The
+// caller must hold the lock.
+func (l *LoudnessSensor) integrated() float64 {
+	var count int
+	var energy float64
+	for _, b := range l.histogram {
+		count += b.count
+		energy += b.energy
+	}
+	if count == 0 {
+		return MinLevel
+	}
+
+	gate := loudness(energy/float64(count)) + relativeGate
+	count, energy = 0, 0
+	for i, b := range l.histogram {
+		// bins straddling the gate are included, so it's accurate to within histStep
+		if histMin+float64(i+1)*histStep <= gate {
+			continue
+		}
+		count += b.count
+		energy += b.energy
+	}
+	if count == 0 {
+		return MinLevel
+	}
+	return loudness(energy / float64(count))
+}
+
+// loudness converts a K-weighted mean square to LUFS.
+func loudness(ms float64) float64 {
+	if ms <= 0 {
+		return MinLevel
+	}
+	return math.Max(MinLevel, -0.691+10*math.Log10(ms))
+}
+
+func decibels(amp float64) float64 {
+	if amp <= 0 {
+		return MinLevel
+	}
+	return math.Max(MinLevel, 20*math.Log10(amp))
+}
+
+func silentLevels() Levels {
+	return Levels{
+		Momentary:  MinLevel,
+		ShortTerm:  MinLevel,
+		Integrated: MinLevel,
+		TruePeak:   MinLevel,
+		AWeighted:  MinLevel,
+	}
+}
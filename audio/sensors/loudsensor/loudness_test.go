@@ -0,0 +1,139 @@
+package loudsensor
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func sine(sampleRate, freq, amp float64, n int) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = amp * math.Sin(2*math.Pi*freq*float64(i)/sampleRate)
+	}
+	return x
+}
+
+func TestKWeighting(t *testing.T) {
+	// the reference coefficients given in BS.1770 for 48kHz
+	k := newKWeighting(48000)
+	want := []float64{1.53512485958697, -2.69169618940638, 1.19839281085285,
+		-1.69065929318241, 0.73248077421585}
+	got := []float64{k[0].b0, k[0].b1, k[0].b2, k[0].a1, k[0].a2}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-6 {
+			t.Errorf("shelf coefficients: want %v, got %v", want, got)
+			break
+		}
+	}
+}
+
+func TestAWeighting(t *testing.T) {
+	sampleRate := 48000.0
+	a := newAWeighting(sampleRate)
+	// IEC 61672 nominal values
+	for freq, want := range map[float64]float64{
+		100:  -19.1,
+		1000: 0,
+		4000: 1.0,
+	} {
+		got := 20 * math.Log10(cmplx.Abs(a.response(freq, sampleRate)))
+		if math.Abs(got-want) > 0.2 {
+			t.Errorf("A-weighting at %vHz: want %vdB, got %.2fdB", freq, want, got)
+		}
+	}
+}
+
+func TestLoudness(t *testing.T) {
+	sampleRate := 48000.0
+	l := NewLoudnessSensor(sampleRate)
+
+	// EBU Tech 3341: a 1kHz sine at -23 dBFS on both stereo channels reads -23 LUFS, so
+	// in mono it needs to be 3dB louder
+	amp := math.Pow(10, -20.0/20)
+	x := sine(sampleRate, 1000, amp, 10*int(sampleRate))
+	var lv *Levels
+	for i := 0; i+1024 <= len(x); i += 1024 {
+		lv = l.process(x[i : i+1024])
+	}
+	t.Logf("%+v", lv)
+
+	for name, v := range map[string]float64{
+		"momentary":  lv.Momentary,
+		"short-term": lv.ShortTerm,
+		"integrated": lv.Integrated,
+	} {
+		if math.Abs(v+23) > 0.1 {
+			t.Errorf("expected %s loudness of -23 LUFS, got %v", name, v)
+		}
+	}
+	if math.Abs(lv.TruePeak+20) > 0.2 {
+		t.Error("expected true peak around -20 dBTP, got", lv.TruePeak)
+	}
+	if math.Abs(lv.AWeighted+20) > 0.2 {
+		t.Error("expected A-weighted level around -20 dB, got", lv.AWeighted)
+	}
+
+	// the relative gate ignores quiet passages
+	quiet := sine(sampleRate, 1000, amp/100, 10*int(sampleRate))
+	for i := 0; i+1024 <= len(quiet); i += 1024 {
+		lv = l.process(quiet[i : i+1024])
+	}
+	if math.Abs(lv.Integrated+23) > 0.2 {
+		t.Error("expected gated integrated loudness to stay around -23 LUFS, got", lv.Integrated)
+	}
+
+	l.Reset()
+	if lv := l.Levels(); lv.Integrated != MinLevel || lv.TruePeak != MinLevel {
+		t.Error("expected reset levels, got", lv)
+	}
+}
+
+func TestTruePeak(t *testing.T) {
+	// a sine at fs/4 sampled 45 degrees off its peaks has sample peaks 3dB below its true
+	// peak
+	tp := newTruePeak()
+	var sample, peak float64
+	for i := 0; i < 256; i++ {
+		x := math.Sin(math.Pi/2*float64(i) + math.Pi/4)
+		sample = math.Max(sample, math.Abs(x))
+		peak = math.Max(peak, tp.apply(x))
+	}
+	if peak < 0.98 || peak > 1.02 {
+		t.Errorf("expected true peak near 1, got %v (sample peak %v)", peak, sample)
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	sampleRate := 48000.0
+	l := NewLoudnessSensor(sampleRate)
+	n := NewNormalizer(l, -13)
+	if g := n.Gain(); g != 1 {
+		t.Error("expected no gain before anything is metered, got", g)
+	}
+
+	// -23 LUFS is raised by 10dB
+	x := sine(sampleRate, 1000, math.Pow(10, -20.0/20), 4*int(sampleRate))
+	for i := 0; i+1024 <= len(x); i += 1024 {
+		l.process(x[i : i+1024])
+	}
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64)
+	out := n.Process(done, in)
+	in <- []float64{0.5, -1}
+	y := <-out
+	want := math.Pow(10, 10.0/20)
+	if math.Abs(y[0]/0.5-want) > 0.05 || math.Abs(y[1]/-1-want) > 0.05 {
+		t.Errorf("expected a gain of %.2f, got %v", want, y)
+	}
+
+	// a quiet signal isn't raised by more than MaxGain
+	quiet := sine(sampleRate, 1000, 1e-4, 4*int(sampleRate))
+	for i := 0; i+1024 <= len(quiet); i += 1024 {
+		l.process(quiet[i : i+1024])
+	}
+	if g := n.Gain(); g != DefaultMaxGain {
+		t.Errorf("expected the gain to be capped at %v, got %v", DefaultMaxGain, g)
+	}
+}
@@ -0,0 +1,63 @@
+package loudsensor
+
+// DefaultMaxGain is how much a Normalizer raises a quiet signal at most, 20dB.
+const DefaultMaxGain = 10.0
+
+// Normalizer scales a signal by the gain that brings the loudness measured by a
+// LoudnessSensor to a target, so that the sensors after it see the same level whether the
+// input is quiet or loud. The sensor has to meter the same signal, usually through a Tee.
+type Normalizer struct {
+	// Target is the short-term loudness to normalize to, in LUFS.
+	Target float64
+	// MaxGain caps the gain so that silence and quiet passages aren't raised into noise.
+	MaxGain float64
+
+	sensor *LoudnessSensor
+}
+
+// NewNormalizer creates a Normalizer to @target LUFS by the levels of @sensor.
+func NewNormalizer(sensor *LoudnessSensor, target float64) *Normalizer {
+	return &Normalizer{
+		Target:  target,
+		MaxGain: DefaultMaxGain,
+		sensor:  sensor,
+	}
+}
+
+// Gain returns the gain that's applied to the next frame.
+func (n *Normalizer) Gain() float64 {
+	g := n.sensor.Gain(n.Target)
+	if n.MaxGain > 0 && g > n.MaxGain {
+		g = n.MaxGain
+	}
+	return g
+}
+
+// Process kicks off a goroutine to scale incoming @in frames and returns the output
+// channel.
+func (n *Normalizer) Process(done chan struct{}, in chan []float64) chan []float64 {
+	out := make(chan []float64)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			x := <-in
+			if x == nil {
+				return
+			}
+			g := n.Gain()
+			y := make([]float64, len(x))
+			for i, v := range x {
+				y[i] = g * v
+			}
+			out <- y
+		}
+	}()
+
+	return out
+}
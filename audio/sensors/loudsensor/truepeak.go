@@ -0,0 +1,57 @@
+package loudsensor
+
+import (
+	"math"
+)
+
+const (
+	oversample    = 4
+	tapsPerPhase  = 12
+	prototypeSize = oversample * tapsPerPhase
+)
+
+// truePeak estimates inter-sample peaks by 4x oversampling with a polyphase windowed sinc
+// interpolator, as suggested in Annex 2 of ITU-R BS.1770.
+type truePeak struct {
+	phases  [oversample][tapsPerPhase]float64
+	history [tapsPerPhase]float64
+	pos     int
+}
+
+func newTruePeak() *truePeak {
+	var h [prototypeSize]float64
+	var sum float64
+	center := float64(prototypeSize-1) / 2
+	for n := range h {
+		x := (float64(n) - center) / oversample
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		hann := 0.5 - 0.5*math.Cos(2*math.Pi*(float64(n)+0.5)/prototypeSize)
+		h[n] = sinc * hann
+		sum += h[n]
+	}
+
+	t := &truePeak{}
+	for n := range h {
+		t.phases[n%oversample][n/oversample] = h[n] * oversample / sum
+	}
+	return t
+}
+
+// apply pushes a sample through the interpolator and returns the largest absolute value
+// among the oversampled outputs.
+func (t *truePeak) apply(x float64) float64 {
+	t.history[t.pos] = x
+	peak := math.Abs(x)
+	for p := range t.phases {
+		var y float64
+		for k, c := range t.phases[p] {
+			y += c * t.history[(t.pos-k+tapsPerPhase)%tapsPerPhase]
+		}
+		peak = math.Max(peak, math.Abs(y))
+	}
+	t.pos = (t.pos + 1) % tapsPerPhase
+	return peak
+}
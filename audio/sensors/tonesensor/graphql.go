@@ -0,0 +1,77 @@
+package tonesensor
+
+import (
+	"errors"
+
+	"github.com/graphql-go/graphql"
+)
+
+var stateType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "ToneType",
+		Fields: graphql.Fields{
+			"name":      &graphql.Field{Type: graphql.String},
+			"frequency": &graphql.Field{Type: graphql.Float},
+			"level":     &graphql.Field{Type: graphql.Float},
+			"active":    &graphql.Field{Type: graphql.Boolean},
+		},
+	},
+)
+
+// QueryFields publishes the state of every target as "tones".
+func (t *ToneSensor) QueryFields() graphql.Fields {
+	return graphql.Fields{
+		"tones": &graphql.Field{
+			Type: graphql.NewList(stateType),
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return t.States(), nil
+			},
+		},
+	}
+}
+
+// MutationFields adds "tone", which adds or replaces a target, and "removeTone".
+func (t *ToneSensor) MutationFields() graphql.Fields {
+	return graphql.Fields{
+		"tone": &graphql.Field{
+			Type: graphql.NewList(stateType),
+			Args: graphql.FieldConfigArgument{
+				"name":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"frequency": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Float)},
+				"bandwidth": &graphql.ArgumentConfig{Type: graphql.Float},
+				"on":        &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Float)},
+				"off":       &graphql.ArgumentConfig{Type: graphql.Float},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				target := Target{
+					Name:      p.Args["name"].(string),
+					Frequency: p.Args["frequency"].(float64),
+					On:        p.Args["on"].(float64),
+				}
+				target.Off = target.On
+				if off, ok := p.Args["off"]; ok {
+					target.Off = off.(float64)
+				}
+				if bw, ok := p.Args["bandwidth"]; ok {
+					target.Bandwidth = bw.(float64)
+				}
+				if err := t.SetTarget(target); err != nil {
+					return nil, err
+				}
+				return t.States(), nil
+			},
+		},
+		"removeTone": &graphql.Field{
+			Type: graphql.NewList(stateType),
+			Args: graphql.FieldConfigArgument{
+				"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if !t.RemoveTarget(p.Args["name"].(string)) {
+					return nil, errors.New("no such tone: " + p.Args["name"].(string))
+				}
+				return t.States(), nil
+			},
+		},
+	}
+}
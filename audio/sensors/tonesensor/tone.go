@@ -0,0 +1,242 @@
+package tonesensor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// MinLevel is the level reported for a target with no energy, in dB.
+const MinLevel = -120.0
+
+// DefaultBandwidth is used for targets that don't specify one.
+const DefaultBandwidth = 10.0
+
+// Target is a frequency to watch for.
+type Target struct {
+	Name      string  `json:"name"`
+	Frequency float64 `json:"frequency"`
+	// Bandwidth is the resolution of the detector in Hz. Narrower bands reject neighboring
+	// tones better but take longer to respond, since each measurement spans
+	// SampleRate/Bandwidth samples.
+	Bandwidth float64 `json:"bandwidth"`
+	// On is the level in dB relative to a full scale sine at which the target turns on.
+	On float64 `json:"on"`
+	// Off is the level below which an active target turns off again. Keeping it below On
+	// adds hysteresis so that a tone hovering around the threshold doesn't chatter.
+	Off float64 `json:"off"`
+}
+
+// Validate checks that the target can be detected at @sampleRate.
+func (t *Target) Validate(sampleRate float64) error {
+	if t.Name == "" {
+		return errors.New("target must have a name")
+	}
+	if t.Frequency <= 0 || t.Frequency >= sampleRate/2 {
+		return fmt.Errorf("target %s: frequency %v out of range", t.Name, t.Frequency)
+	}
+	if t.Bandwidth < 0 {
+		return fmt.Errorf("target %s: bandwidth must be positive", t.Name)
+	}
+	if t.Off > t.On {
+		return fmt.Errorf("target %s: off level %v is above on level %v", t.Name, t.Off, t.On)
+	}
+	return nil
+}
+
+// Event is emitted whenever a target turns on or off.
+type Event struct {
+	Target string
+	On     bool
+	// Level is the level of the target in dB at the time of the event.
+	Level float64
+	// Time is the position in the input stream at which the event was detected.
+	Time time.Duration
+}
+
+// State is the current state of a target.
+type State struct {
+	Name      string  `json:"name"`
+	Frequency float64 `json:"frequency"`
+	Level     float64 `json:"level"`
+	Active    bool    `json:"active"`
+}
+
+// detector runs the Goertzel algorithm over consecutive blocks of samples.
+type detector struct {
+	Target
+	size   int
+	coeff  float64
+	s1, s2 float64
+	n      int
+
+	level  float64
+	active bool
+}
+
+func newDetector(t Target, sampleRate float64) *detector {
+	if t.Bandwidth == 0 {
+		t.Bandwidth = DefaultBandwidth
+	}
+	return &detector{
+		Target: t,
+		size:   int(math.Ceil(sampleRate / t.Bandwidth)),
+		coeff:  2 * math.Cos(2*math.Pi*t.Frequency/sampleRate),
+		level:  MinLevel,
+	}
+}
+
+// push adds a sample and returns true when a block completes and the level is updated.
+func (d *detector) push(x float64) bool {
+	s := x + d.coeff*d.s1 - d.s2
+	d.s2 = d.s1
+	d.s1 = s
+	d.n++
+	if d.n < d.size {
+		return false
+	}
+
+	power := d.s1*d.s1 + d.s2*d.s2 - d.coeff*d.s1*d.s2
+	// a sine of amplitude A yields a magnitude of A*N/2
+	amp := 2 * math.Sqrt(math.Max(power, 0)) / float64(d.size)
+	d.level = MinLevel
+	if amp > 0 {
+		d.level = math.Max(MinLevel, 20*math.Log10(amp))
+	}
+
+	d.s1, d.s2, d.n = 0, 0, 0
+	return true
+}
+
+// ToneSensor is a bank of Goertzel detectors, each tracking a single target frequency.
+// It's much cheaper than a full FFT when only a few frequencies matter.
+type ToneSensor struct {
+	SampleRate float64
+
+	lock      sync.Mutex
+	detectors []*detector
+	position  int64
+}
+
+// NewToneSensor creates a new ToneSensor for a signal at @sampleRate watching @targets.
+func NewToneSensor(sampleRate float64, targets []Target) (*ToneSensor, error) {
+	t := &ToneSensor{SampleRate: sampleRate}
+	for _, tg := range targets {
+		if err := t.SetTarget(tg); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// SetTarget adds a target or replaces the one with the same name.
+func (t *ToneSensor) SetTarget(target Target) error {
+	if err := target.Validate(t.SampleRate); err != nil {
+		return err
+	}
+	d := newDetector(target, t.SampleRate)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for i := range t.detectors {
+		if t.detectors[i].Name == target.Name {
+			t.detectors[i] = d
+			return nil
+		}
+	}
+	t.detectors = append(t.detectors, d)
+	return nil
+}
+
+// RemoveTarget stops watching the target with @name.
+func (t *ToneSensor) RemoveTarget(name string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for i := range t.detectors {
+		if t.detectors[i].Name == name {
+			t.detectors = append(t.detectors[:i], t.detectors[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// States returns the current state of every target.
+func (t *ToneSensor) States() []State {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	states := make([]State, len(t.detectors))
+	for i, d := range t.detectors {
+		states[i] = State{
+			Name:      d.Name,
+			Frequency: d.Frequency,
+			Level:     d.level,
+			Active:    d.active,
+		}
+	}
+	return states
+}
+
+// Process kicks off a goroutine to run incoming @in frames through the detectors and
+// returns a channel of on/off events.
+func (t *ToneSensor) Process(done chan struct{}, in chan []float64) chan Event {
+	out := make(chan Event, 16)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			x := <-in
+			if x == nil {
+				return
+			}
+			for _, ev := range t.process(x) {
+				select {
+				case out <- ev:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+func (t *ToneSensor) process(frame []float64) []Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var events []Event
+	for i, x := range frame {
+		for _, d := range t.detectors {
+			if !d.push(x) {
+				continue
+			}
+			on := d.active
+			if !d.active && d.level >= d.On {
+				on = true
+			} else if d.active && d.level < d.Off {
+				on = false
+			}
+			if on != d.active {
+				d.active = on
+				pos := t.position + int64(i) + 1
+				events = append(events, Event{
+					Target: d.Name,
+					On:     on,
+					Level:  d.level,
+					Time:   time.Duration(float64(pos) / t.SampleRate * float64(time.Second)),
+				})
+			}
+		}
+	}
+	t.position += int64(len(frame))
+	return events
+}
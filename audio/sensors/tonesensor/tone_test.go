@@ -0,0 +1,77 @@
+package tonesensor
+
+import (
+	"math"
+	"testing"
+)
+
+const testSampleRate = 44100
+
+func tone(freq, amp float64, n int) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = amp * math.Sin(2*math.Pi*freq*float64(i)/testSampleRate)
+	}
+	return x
+}
+
+func TestToneSensor(t *testing.T) {
+	ts, err := NewToneSensor(testSampleRate, []Target{
+		{Name: "hum", Frequency: 50, Bandwidth: 5, On: -30, Off: -36},
+		{Name: "cue", Frequency: 1000, On: -20, Off: -26},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(x []float64) []Event {
+		var events []Event
+		for i := 0; i+512 <= len(x); i += 512 {
+			events = append(events, ts.process(x[i:i+512])...)
+		}
+		return events
+	}
+
+	// a loud cue tone with some quiet hum underneath only triggers the cue
+	x := tone(1000, 0.5, testSampleRate)
+	for i, h := range tone(50, 0.005, testSampleRate) {
+		x[i] += h
+	}
+	events := run(x)
+	if len(events) != 1 || events[0].Target != "cue" || !events[0].On {
+		t.Fatal("expected the cue to turn on:", events)
+	}
+	for _, s := range ts.States() {
+		t.Logf("%+v", s)
+		if s.Name == "cue" && math.Abs(s.Level-20*math.Log10(0.5)) > 0.5 {
+			t.Error("expected the cue level to match its amplitude:", s.Level)
+		}
+	}
+
+	// a level between the on and off thresholds doesn't release it
+	events = run(tone(1000, math.Pow(10, -23.0/20), testSampleRate))
+	if len(events) != 0 {
+		t.Error("expected hysteresis to hold the cue on:", events)
+	}
+
+	events = run(make([]float64, testSampleRate))
+	if len(events) != 1 || events[0].Target != "cue" || events[0].On {
+		t.Fatal("expected the cue to turn off:", events)
+	}
+	if events[0].Time.Seconds() < 2 || events[0].Time.Seconds() > 3 {
+		t.Error("expected the release in the third second:", events[0].Time)
+	}
+}
+
+func TestTargetValidate(t *testing.T) {
+	for _, tg := range []Target{
+		{Frequency: 100},
+		{Name: "a", Frequency: 0},
+		{Name: "a", Frequency: testSampleRate},
+		{Name: "a", Frequency: 100, On: -30, Off: -20},
+	} {
+		if err := tg.Validate(testSampleRate); err == nil {
+			t.Errorf("expected %+v to be invalid", tg)
+		}
+	}
+}
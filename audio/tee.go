@@ -0,0 +1,42 @@
+package audio
+
+// Tee copies every incoming frame to @n output channels. Each output gets its own copy so
+// that processors which modify frames in place, like the FFT window, don't interfere with
+// each other. Every output has to be read from or the pipeline stalls.
+func Tee(done chan struct{}, in <-chan []float64, n int) []chan []float64 {
+	outs := make([]chan []float64, n)
+	for i := range outs {
+		outs[i] = make(chan []float64)
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			x := <-in
+			if x == nil {
+				return
+			}
+
+			for _, out := range outs {
+				y := make([]float64, len(x))
+				copy(y, x)
+				select {
+				case out <- y:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return outs
+}
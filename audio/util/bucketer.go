@@ -1,132 +1,244 @@
 package util
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"math"
+	"sync"
 )
 
-// Scale represents the scale that is used to calculate bucket indices.
-type Scale interface {
-	To(float64) float64
-	From(float64) float64
+// ScaleEdges returns the @buckets+1 frequencies in Hz that split [@fMin, @fMax] into
+// buckets that are evenly spaced on @scale.
+func ScaleEdges(scale Scale, buckets int, fMin, fMax float64) []float64 {
+	sMin := scale.To(fMin)
+	sMax := scale.To(fMax)
+	space := (sMax - sMin) / float64(buckets)
+	edges := make([]float64, buckets+1)
+	for i := range edges {
+		edges[i] = scale.From(sMin + float64(i)*space)
+	}
+	edges[0] = fMin
+	edges[buckets] = fMax
+	return edges
 }
 
-type melScale struct{}
-
-// MelScale is a scale defined by how humans perceive pitch differences.
-var MelScale *melScale
-
-func (s *melScale) To(val float64) float64 {
-	return 1127 * math.Log(1+val/700)
+// BucketerConfig describes how a Bucketer splits a spectrum.
+type BucketerConfig struct {
+	Scale   Scale
+	Buckets int
+	// Size is the number of bins in each incoming spectrum, which is half the FFT size.
+	Size       int
+	SampleRate float64
+	// FMin and FMax are the range that gets split into buckets.
+	FMin float64
+	FMax float64
 }
 
-func (s *melScale) From(val float64) float64 {
-	return 700 * (math.Exp(val/1127.0) - 1)
+// Validate checks that a Bucketer can be built from the config.
+func (c *BucketerConfig) Validate() error {
+	if c.Scale == nil {
+		return errors.New("bucketer: scale is required")
+	}
+	if c.Buckets <= 0 {
+		return fmt.Errorf("bucketer: bucket count %d must be positive", c.Buckets)
+	}
+	if c.Size <= 1 {
+		return fmt.Errorf("bucketer: frame size %d too small", c.Size)
+	}
+	if c.SampleRate <= 0 {
+		return fmt.Errorf("bucketer: sample rate %v must be positive", c.SampleRate)
+	}
+	if c.FMin <= 0 || c.FMax <= c.FMin {
+		return fmt.Errorf("bucketer: invalid frequency range [%v, %v]", c.FMin, c.FMax)
+	}
+	if c.FMin >= c.SampleRate/2 {
+		return fmt.Errorf("bucketer: minimum frequency %v is above nyquist", c.FMin)
+	}
+	return nil
 }
 
-type logScale struct{}
+// Bucketer puts the specturn into N buckets whose edges are evenly spaced on a frequency
+// scale. Bins that straddle an edge are split between the buckets on either side by how
+// much of the bin lies in each, and buckets narrower than a bin are interpolated from the
+// bins around their center, so no bucket is ever empty or a copy of its neighbor.
+//
+// A Bucketer never changes once it's built; use a BucketProcessor to change buckets while
+// frames are flowing.
+type Bucketer struct {
+	BucketerConfig
 
-// LogScale is a typical log2 scale.
-var LogScale *logScale
+	edges []float64
+	bands []band
+}
 
-func (s *logScale) To(val float64) float64 {
-	return math.Log2(1 + val)
+// band holds the weight of each bin from start on that contributes to a bucket.
+type band struct {
+	start   int
+	weights []float64
 }
-func (s *logScale) From(val float64) float64 {
-	return math.Exp2(val) - 1
+
+// NewBucketer creates a new Bucketer for a spectrum of @frameSize bins (half the FFT size)
+// sampled at @sampleRate, splitting [@fMin, @fMax] into N @buckets evenly spaced on @scale.
+func NewBucketer(scale Scale, buckets, frameSize int, sampleRate, fMin, fMax float64) (*Bucketer, error) {
+	return NewBucketerFromConfig(BucketerConfig{
+		Scale:      scale,
+		Buckets:    buckets,
+		Size:       frameSize,
+		SampleRate: sampleRate,
+		FMin:       fMin,
+		FMax:       fMax,
+	})
 }
 
-// Bucketer puts the specturn into N buckets using a mel frequency scale
-type Bucketer struct {
-	Buckets int
-	Size    int
-	Scale   Scale
+// NewBucketerFromConfig creates a new Bucketer from a BucketerConfig.
+func NewBucketerFromConfig(cfg BucketerConfig) (*Bucketer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	nyquist := cfg.SampleRate / 2
+	if cfg.FMax > nyquist {
+		cfg.FMax = nyquist
+	}
+	edges := ScaleEdges(cfg.Scale, cfg.Buckets, cfg.FMin, cfg.FMax)
+	// the width of a bin in Hz
+	df := nyquist / float64(cfg.Size)
+
+	bands := make([]band, cfg.Buckets)
+	for i := range bands {
+		bands[i] = newBand(edges[i], edges[i+1], df, cfg.Size)
+	}
 
-	// generate N-1 indices to split a frame into N Buckets
-	indices []int
+	return &Bucketer{
+		BucketerConfig: cfg,
+		edges:          edges,
+		bands:          bands,
+	}, nil
 }
 
-// NewBucketer creates a new Bucketer for a frame of @frameSize based on @scale and N @buckets,
-// starting with [0:@fMin] and going to [@fMax:<nyquist>].
-func NewBucketer(scale Scale, buckets, frameSize int, fMin, fMax float64) *Bucketer {
-	sMin := scale.To(fMin)
-	sMax := scale.To(fMax)
-	space := (sMax - sMin) / float64(buckets)
-	indices := make([]int, buckets-1)
-	lastIdx := 0
-	offset := 1
-	// in scale space, how far is a unit index
-	offsetDelta := (sMax - sMin) / float64(frameSize)
-
-	for i := range indices {
-		// the bucket spacing needs to adjust if we've accumulated offset
-		adjSpace := space - offsetDelta*float64(offset)/float64(buckets)
-
-		v := scale.From(float64(i+1)*(adjSpace) + sMin + offsetDelta*float64(offset))
-		idx := int(math.Ceil(
-			float64(frameSize) * v / fMax))
-
-		// this is a special edge case when requested number of buckets is too high
-		// and we'd otherwise have two sequential index values that round to the same number.
-		if idx <= lastIdx {
-			idx = lastIdx + 1
-			offset++
-		}
-		if idx >= frameSize {
-			idx = frameSize - 1
+// newBand computes the bin weights for the bucket [lo, hi). Bin k is centered at k*df and
+// spans half a bin to either side.
+func newBand(lo, hi, df float64, size int) band {
+	if hi-lo < df {
+		// narrower than a bin: interpolate linearly at the center of the bucket
+		pos := (lo + hi) / 2 / df
+		i := int(pos)
+		if i >= size-1 {
+			return band{start: size - 1, weights: []float64{1}}
 		}
+		frac := pos - float64(i)
+		return band{start: i, weights: []float64{1 - frac, frac}}
+	}
 
-		indices[i] = idx
-		lastIdx = idx
+	start := int(math.Floor(lo/df + 0.5))
+	stop := int(math.Ceil(hi/df - 0.5))
+	if stop >= size {
+		stop = size - 1
+	}
+	b := band{start: start}
+	for k := start; k <= stop; k++ {
+		binLo := (float64(k) - 0.5) * df
+		binHi := (float64(k) + 0.5) * df
+		overlap := math.Min(hi, binHi) - math.Max(lo, binLo)
+		b.weights = append(b.weights, math.Max(0, overlap/df))
 	}
+	return b
+}
 
-	return &Bucketer{
-		Buckets: buckets,
-		Size:    frameSize,
-		Scale:   scale,
-		indices: indices,
+// Edges returns the Buckets+1 frequencies in Hz that separate the buckets.
+func (b *Bucketer) Edges() []float64 {
+	return append([]float64(nil), b.edges...)
+}
+
+// Centers returns the center frequency in Hz of each bucket, measured on the Scale.
+func (b *Bucketer) Centers() []float64 {
+	centers := make([]float64, b.Buckets)
+	for i := range centers {
+		lo := b.Scale.To(b.edges[i])
+		hi := b.Scale.To(b.edges[i+1])
+		centers[i] = b.Scale.From((lo + hi) / 2)
 	}
+	return centers
 }
 
-// Bucket applys b.Buckets rectangular windows on the incoming frame and returns the sum in
-// each window in a len==b.Buckets []float64.
-func (b *Bucketer) Bucket(frame []float64) []float64 {
-	buckets := make([]float64, b.Buckets)
+// FrameSizeError is returned by Bucket when a frame doesn't match the Bucketer's size.
+type FrameSizeError struct {
+	Got, Want int
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("frame size %d does not match bucket size %d", e.Got, e.Want)
+}
+
+// Bucket applys b.Buckets windows on the incoming frame and returns the weighted average
+// in each window in a len==b.Buckets []float64.
+func (b *Bucketer) Bucket(frame []float64) ([]float64, error) {
 	if len(frame) != b.Size {
-		log.Fatalf("Frame size %d does not match bucket size %d", len(frame), b.Size)
-	}
-	for i := range buckets {
-		var start, stop int
-		if i == 0 {
-			start = 0
-		} else {
-			start = b.indices[i-1]
-		}
-		if i == len(buckets)-1 {
-			stop = len(frame)
-		} else {
-			stop = b.indices[i]
+		return nil, &FrameSizeError{Got: len(frame), Want: b.Size}
+	}
+	buckets := make([]float64, b.Buckets)
+	for i, band := range b.bands {
+		var sum, weight float64
+		for j, w := range band.weights {
+			sum += w * frame[band.start+j]
+			weight += w
 		}
-		var sum float64
-		for j := start; j < stop; j++ {
-			sum += frame[j]
+		if weight > 0 {
+			buckets[i] = sum / weight
 		}
-		buckets[i] = sum / float64(stop-start)
 	}
-	return buckets
+	return buckets, nil
 }
 
 // BucketProcessor is an asynchronous processor that puts incoming frames into buckets.
+// Its Bucketer can be replaced while it runs, and it adapts to frames that change size.
 type BucketProcessor struct {
-	Bucketer *Bucketer
+	lock     sync.Mutex
+	bucketer *Bucketer
 }
 
 // NewBucketProcessor creates a new bucket processor using a Bucketer.
 func NewBucketProcessor(b *Bucketer) *BucketProcessor {
-	return &BucketProcessor{b}
+	return &BucketProcessor{bucketer: b}
+}
+
+// Bucketer returns the Bucketer that the next frame will be bucketed with.
+func (b *BucketProcessor) Bucketer() *Bucketer {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.bucketer
+}
+
+// Rebuild applies @update to a copy of the current config and replaces the Bucketer with
+// one built from it. The new Bucketer takes effect on the next frame, so nothing is
+// dropped. The current Bucketer is kept if the new config is invalid.
+func (b *BucketProcessor) Rebuild(update func(*BucketerConfig)) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	cfg := b.bucketer.BucketerConfig
+	update(&cfg)
+	nb, err := NewBucketerFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	b.bucketer = nb
+	return nil
+}
+
+// Bucket buckets a single frame, rebuilding the Bucketer first if the frame size changed.
+func (b *BucketProcessor) Bucket(x []float64) ([]float64, error) {
+	bucketer := b.Bucketer()
+	if len(x) != bucketer.Size {
+		if err := b.Rebuild(func(cfg *BucketerConfig) { cfg.Size = len(x) }); err != nil {
+			return nil, err
+		}
+		bucketer = b.Bucketer()
+	}
+	return bucketer.Bucket(x)
 }
 
 // Process kicks off a goroutine to process incoming @in frames and returns the output channel.
+// Frames that can't be bucketed are logged and skipped.
 func (b *BucketProcessor) Process(done chan struct{}, in chan []float64) chan []float64 {
 	out := make(chan []float64)
 
@@ -142,7 +254,12 @@ func (b *BucketProcessor) Process(done chan struct{}, in chan []float64) chan []
 			if x == nil {
 				return
 			}
-			out <- b.Bucketer.Bucket(x)
+			y, err := b.Bucket(x)
+			if err != nil {
+				log.Println("[ERROR] bucketer:", err)
+				continue
+			}
+			out <- y
 		}
 	}()
 
@@ -1,6 +1,9 @@
 package util
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestBucketer(t *testing.T) {
 	size := 512
@@ -10,14 +13,114 @@ func TestBucketer(t *testing.T) {
 	}
 
 	// test and print MelScale
-	b := NewBucketer(MelScale, 64, size, 32, 16000)
-	t.Log(b.indices)
-	buckets := b.Bucket(frame)
+	b, err := NewBucketer(MelScale, 64, size, 44100, 32, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(b.Edges())
+	buckets, err := b.Bucket(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Log(buckets, len(buckets))
 
 	// test and print LogScale
-	b = NewBucketer(LogScale, 60, size, 32, 16000)
-	t.Log(b.indices)
-	buckets = b.Bucket(frame)
+	b, err = NewBucketer(LogScale, 60, size, 44100, 32, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(b.Edges())
+	buckets, err = b.Bucket(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Log(buckets, len(buckets))
+
+	for i, v := range buckets {
+		if math.Abs(v-1) > 1e-9 {
+			t.Errorf("bucket %d of a flat spectrum should be 1, got %v", i, v)
+		}
+	}
+}
+
+func TestBucketerDense(t *testing.T) {
+	// many more buckets than bins in the low end
+	size := 256
+	sampleRate := 44100.0
+	b, err := NewBucketer(LogScale, 64, size, sampleRate, 32, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a ramp makes every bucket's value its center in bins
+	df := sampleRate / 2 / float64(size)
+	frame := make([]float64, size)
+	for i := range frame {
+		frame[i] = float64(i) * df
+	}
+	buckets, err := b.Bucket(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edges := b.Edges()
+	centers := b.Centers()
+	for i := range buckets {
+		if i > 0 && buckets[i] <= buckets[i-1] {
+			t.Errorf("bucket %d isn't distinct from the one below: %v <= %v",
+				i, buckets[i], buckets[i-1])
+		}
+		if buckets[i] < edges[i]-df/2 || buckets[i] > edges[i+1]+df/2 {
+			t.Errorf("bucket %d [%v, %v] got value from outside its range: %v",
+				i, edges[i], edges[i+1], buckets[i])
+		}
+		if centers[i] < edges[i] || centers[i] > edges[i+1] {
+			t.Errorf("bucket %d center %v is outside [%v, %v]", i, centers[i], edges[i], edges[i+1])
+		}
+	}
+}
+
+func TestBucketProcessorRebuild(t *testing.T) {
+	b, err := NewBucketer(LogScale, 16, 512, 44100, 32, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Bucket(make([]float64, 256)); err == nil {
+		t.Error("expected a frame size error")
+	}
+
+	p := NewBucketProcessor(b)
+	done := make(chan struct{})
+	defer close(done)
+	in := make(chan []float64)
+	out := p.Process(done, in)
+
+	in <- make([]float64, 512)
+	if y := <-out; len(y) != 16 {
+		t.Fatal("expected 16 buckets, got", len(y))
+	}
+
+	// the frame size changes and the processor follows
+	in <- make([]float64, 1024)
+	if y := <-out; len(y) != 16 || p.Bucketer().Size != 1024 {
+		t.Fatal("expected the bucketer to adapt to the new frame size")
+	}
+
+	if err := p.Rebuild(func(cfg *BucketerConfig) {
+		cfg.Buckets = 24
+		cfg.Scale = MelScale
+	}); err != nil {
+		t.Fatal(err)
+	}
+	in <- make([]float64, 1024)
+	if y := <-out; len(y) != 24 {
+		t.Fatal("expected 24 buckets after rebuild, got", len(y))
+	}
+
+	if err := p.Rebuild(func(cfg *BucketerConfig) { cfg.Buckets = 0 }); err == nil {
+		t.Error("expected invalid rebuild to fail")
+	}
+	if p.Bucketer().Buckets != 24 {
+		t.Error("expected a failed rebuild to keep the current bucketer")
+	}
 }
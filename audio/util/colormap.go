@@ -3,11 +3,43 @@
 package util
 
 import (
+	"encoding/json"
+	"fmt"
 	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 
 	colorful "github.com/lucasb-eyer/go-colorful"
 )
 
+// BlendSpace is the color space in which neighboring keypoints of a gradient are blended.
+type BlendSpace string
+
+// Supported blend spaces
+const (
+	BlendHCL BlendSpace = "hcl"
+	BlendLab BlendSpace = "lab"
+	BlendLuv BlendSpace = "luv"
+	BlendRGB BlendSpace = "rgb"
+)
+
+func (s BlendSpace) blend(c1, c2 colorful.Color, t float64) (colorful.Color, error) {
+	switch s {
+	case BlendHCL, "":
+		return c1.BlendHcl(c2, t), nil
+	case BlendLab:
+		return c1.BlendLab(c2, t), nil
+	case BlendLuv:
+		return c1.BlendLuv(c2, t), nil
+	case BlendRGB:
+		return c1.BlendRgb(c2, t), nil
+	}
+	return colorful.Color{}, fmt.Errorf("unknown blend space: %q", s)
+}
+
 // This table contains the "keypoints" of the colorgradient you want to generate.
 // The position of each keypoint has to live in the range [0,1]
 type colorTable []struct {
@@ -15,17 +47,21 @@ type colorTable []struct {
 	Pos float64
 }
 
-// This is the meat of the gradient computation. It returns a HCL-blend between
+// This is the meat of the gradient computation. It returns a blend between
 // the two colors around `t`.
 // Note: It relies heavily on the fact that the gradient keypoints are sorted.
-func (g colorTable) getInterpolatedColorFor(t float64) colorful.Color {
+func (g colorTable) getInterpolatedColorFor(t float64, space BlendSpace) colorful.Color {
+	if t <= g[0].Pos {
+		return g[0].Col
+	}
 	for i := 0; i < len(g)-1; i++ {
 		c1 := g[i]
 		c2 := g[i+1]
 		if c1.Pos <= t && t <= c2.Pos {
 			// We are in between c1 and c2. Go blend them!
 			t := (t - c1.Pos) / (c2.Pos - c1.Pos)
-			return c1.Col.BlendHcl(c2.Col, t).Clamped()
+			c, _ := space.blend(c1.Col, c2.Col, t)
+			return c.Clamped()
 		}
 	}
 
@@ -43,31 +79,245 @@ func mustParseHex(s string) colorful.Color {
 	return c
 }
 
-// ColorMap represents a linear colormap array.
-type ColorMap []color.RGBA
+// ColorMap is a continuous gradient between a set of keypoint colors.
+type ColorMap struct {
+	Name  string
+	Space BlendSpace
+
+	table colorTable
+}
+
+// Keypoint is a color at a position in [0,1] along a gradient.
+type Keypoint struct {
+	Color string  `json:"color"`
+	Pos   float64 `json:"pos"`
+}
+
+// NewColorMap creates a gradient that blends in @space between @keypoints, whose colors
+// are given in hex.
+func NewColorMap(name string, space BlendSpace, keypoints []Keypoint) (*ColorMap, error) {
+	if len(keypoints) < 2 {
+		return nil, fmt.Errorf("colormap %s: at least two keypoints are required", name)
+	}
+	if _, err := space.blend(colorful.Color{}, colorful.Color{}, 0); err != nil {
+		return nil, fmt.Errorf("colormap %s: %v", name, err)
+	}
+	table := make(colorTable, len(keypoints))
+	for i, k := range keypoints {
+		c, err := colorful.Hex(k.Color)
+		if err != nil {
+			return nil, fmt.Errorf("colormap %s: %v", name, err)
+		}
+		if k.Pos < 0 || k.Pos > 1 {
+			return nil, fmt.Errorf("colormap %s: position %v is outside [0,1]", name, k.Pos)
+		}
+		table[i].Col = c
+		table[i].Pos = k.Pos
+	}
+	sort.SliceStable(table, func(i, j int) bool { return table[i].Pos < table[j].Pos })
+	return &ColorMap{Name: name, Space: space, table: table}, nil
+}
+
+// NewEvenColorMap creates a gradient with the hex @colors spaced evenly along it.
+func NewEvenColorMap(name string, space BlendSpace, colors ...string) (*ColorMap, error) {
+	keypoints := make([]Keypoint, len(colors))
+	for i, c := range colors {
+		keypoints[i] = Keypoint{Color: c}
+		if len(colors) > 1 {
+			keypoints[i].Pos = float64(i) / float64(len(colors)-1)
+		}
+	}
+	return NewColorMap(name, space, keypoints)
+}
+
+func mustEvenColorMap(name string, space BlendSpace, colors ...string) *ColorMap {
+	cm, err := NewEvenColorMap(name, space, colors...)
+	if err != nil {
+		panic(err)
+	}
+	return cm
+}
+
+// At returns the color at @t, which is clamped to [0,1].
+func (c *ColorMap) At(t float64) color.RGBA {
+	r, g, b := c.Color(t).RGB255()
+	return color.RGBA{r, g, b, 255}
+}
 
-// NewColorMap creates a colormap of @size by interpolating colors for each value along
-// the hard-coded gradient.
-func NewColorMap(size int) ColorMap {
-	// The "keypoints" of the gradient.
-	table := colorTable{
-		{mustParseHex("#5e4fa2"), 0.0},
-		{mustParseHex("#3288bd"), 0.1},
-		{mustParseHex("#66c2a5"), 0.2},
-		{mustParseHex("#abdda4"), 0.3},
-		{mustParseHex("#e6f598"), 0.4},
-		{mustParseHex("#ffffbf"), 0.5},
-		{mustParseHex("#fee090"), 0.6},
-		{mustParseHex("#fdae61"), 0.7},
-		{mustParseHex("#f46d43"), 0.8},
-		{mustParseHex("#d53e4f"), 0.9},
-		{mustParseHex("#9e0142"), 1.0},
+// Color returns the color at @t, which is clamped to [0,1], for further manipulation.
+func (c *ColorMap) Color(t float64) colorful.Color {
+	if t < 0 {
+		t = 0
+	} else if t > 1 {
+		t = 1
 	}
+	return c.table.getInterpolatedColorFor(t, c.Space)
+}
 
+// Table samples the gradient at @size evenly spaced points, for renderers that index
+// colors by an integer level.
+func (c *ColorMap) Table(size int) []color.RGBA {
 	colors := make([]color.RGBA, size)
 	for i := 0; i < size; i++ {
-		r, g, b := table.getInterpolatedColorFor(float64(i) / float64(size)).RGB255()
-		colors[i] = color.RGBA{r, g, b, 255}
+		colors[i] = c.At(float64(i) / float64(size))
 	}
 	return colors
 }
+
+var (
+	colorMapLock sync.RWMutex
+	colorMaps    = map[string]*ColorMap{}
+)
+
+func init() {
+	for _, cm := range []*ColorMap{
+		{
+			Name:  "spectral",
+			Space: BlendHCL,
+			table: colorTable{
+				{mustParseHex("#5e4fa2"), 0.0},
+				{mustParseHex("#3288bd"), 0.1},
+				{mustParseHex("#66c2a5"), 0.2},
+				{mustParseHex("#abdda4"), 0.3},
+				{mustParseHex("#e6f598"), 0.4},
+				{mustParseHex("#ffffbf"), 0.5},
+				{mustParseHex("#fee090"), 0.6},
+				{mustParseHex("#fdae61"), 0.7},
+				{mustParseHex("#f46d43"), 0.8},
+				{mustParseHex("#d53e4f"), 0.9},
+				{mustParseHex("#9e0142"), 1.0},
+			},
+		},
+		mustEvenColorMap("viridis", BlendLab,
+			"#440154", "#482878", "#3e4989", "#31688e", "#26828e",
+			"#1f9e89", "#35b779", "#6ece58", "#b5de2b", "#fde725"),
+		mustEvenColorMap("magma", BlendLab,
+			"#000004", "#180f3d", "#440f76", "#721f81", "#9e2f7f",
+			"#cd4071", "#f1605d", "#fd9668", "#feca8d", "#fcfdbf"),
+		mustEvenColorMap("inferno", BlendLab,
+			"#000004", "#1b0c41", "#4a0c6b", "#781c6d", "#a52c60",
+			"#cf4446", "#ed6925", "#fb9b06", "#f7d13d", "#fcffa4"),
+		mustEvenColorMap("plasma", BlendLab,
+			"#0d0887", "#46039f", "#7201a8", "#9c179e", "#bd3786",
+			"#d8576b", "#ed7953", "#fb9f3a", "#fdca26", "#f0f921"),
+		mustEvenColorMap("cividis", BlendLab,
+			"#00204d", "#00336f", "#39486b", "#575d6d", "#707173",
+			"#8a8779", "#a69d75", "#c4b56c", "#e4cf5b", "#ffea46"),
+		mustEvenColorMap("turbo", BlendRGB,
+			"#30123b", "#4145ab", "#4675ed", "#39a2fc", "#1bcfd4",
+			"#24eca6", "#61fc6c", "#a4fc3b", "#d1e834", "#f3c63a",
+			"#fe9b2d", "#f36315", "#d93806", "#b11901", "#7a0403"),
+		mustEvenColorMap("grayscale", BlendRGB, "#000000", "#ffffff"),
+		mustEvenColorMap("fire", BlendLab,
+			"#000000", "#5c0000", "#b30000", "#ff3300", "#ff9900", "#ffff66", "#ffffff"),
+		mustEvenColorMap("ice", BlendLab,
+			"#000000", "#0b1f4d", "#1f4e9c", "#3c8fd6", "#8fd3f4", "#ffffff"),
+		mustEvenColorMap("coolwarm", BlendLab,
+			"#3b4cc0", "#7396f5", "#b0cbfc", "#dddddd", "#f6bfa6", "#ee8468", "#b40426"),
+	} {
+		RegisterColorMap(cm)
+	}
+}
+
+// RegisterColorMap makes a colormap available to ColorMapByName.
+func RegisterColorMap(cm *ColorMap) {
+	colorMapLock.Lock()
+	defer colorMapLock.Unlock()
+	colorMaps[cm.Name] = cm
+}
+
+// ColorMapByName returns a registered colormap.
+func ColorMapByName(name string) (*ColorMap, error) {
+	colorMapLock.RLock()
+	defer colorMapLock.RUnlock()
+	cm, ok := colorMaps[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown colormap: %q", name)
+	}
+	return cm, nil
+}
+
+// ColorMapNames returns the names of all registered colormaps in order.
+func ColorMapNames() []string {
+	colorMapLock.RLock()
+	defer colorMapLock.RUnlock()
+	names := make([]string, 0, len(colorMaps))
+	for name := range colorMaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// colorMapJSON is the file format of a colormap. Either Colors, which are spaced evenly, or
+// Keypoints must be given.
+type colorMapJSON struct {
+	Name      string     `json:"name"`
+	Space     BlendSpace `json:"space"`
+	Colors    []string   `json:"colors"`
+	Keypoints []Keypoint `json:"keypoints"`
+}
+
+// ParseColorMaps parses a single colormap or a list of them from JSON such as
+//
+//	[{"name": "sunset", "space": "lab", "colors": ["#1a0533", "#ff5e3a", "#ffd23f"]},
+//	 {"name": "sea", "keypoints": [{"color": "#001", "pos": 0}, {"color": "#0af", "pos": 1}]}]
+func ParseColorMaps(data []byte) ([]*ColorMap, error) {
+	var defs []colorMapJSON
+	if err := json.Unmarshal(data, &defs); err != nil {
+		var def colorMapJSON
+		if err := json.Unmarshal(data, &def); err != nil {
+			return nil, err
+		}
+		defs = []colorMapJSON{def}
+	}
+
+	cms := make([]*ColorMap, len(defs))
+	for i, def := range defs {
+		if def.Name == "" {
+			return nil, fmt.Errorf("colormap %d has no name", i)
+		}
+		var err error
+		if len(def.Keypoints) > 0 {
+			cms[i], err = NewColorMap(def.Name, def.Space, def.Keypoints)
+		} else {
+			cms[i], err = NewEvenColorMap(def.Name, def.Space, def.Colors...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cms, nil
+}
+
+// LoadColorMaps registers the colormaps defined in the JSON file at @path, or in every
+// .json file in it if it's a directory, and returns their names.
+func LoadColorMaps(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		cms, err := ParseColorMaps(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		for _, cm := range cms {
+			RegisterColorMap(cm)
+			names = append(names, cm.Name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestColorMaps(t *testing.T) {
+	for _, name := range []string{"spectral", "viridis", "magma", "inferno", "plasma", "turbo",
+		"grayscale", "fire", "ice"} {
+		if _, err := ColorMapByName(name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	gray, err := ColorMapByName("grayscale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := gray.At(-1); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Error("expected black below the range, got", c)
+	}
+	if c := gray.At(2); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Error("expected white above the range, got", c)
+	}
+	if c := gray.At(0.5); c.R < 126 || c.R > 129 || c.R != c.G || c.G != c.B {
+		t.Error("expected mid gray, got", c)
+	}
+	if tab := gray.Table(256); len(tab) != 256 || tab[0].R != 0 || tab[255].R < 253 {
+		t.Error("unexpected table", tab[0], tab[len(tab)-1])
+	}
+}
+
+func TestLoadColorMaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colormaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := `[
+		{"name": "test-even", "space": "rgb", "colors": ["#ff0000", "#0000ff"]},
+		{"name": "test-keys", "space": "lab", "keypoints": [
+			{"color": "#00ff00", "pos": 1}, {"color": "#000000", "pos": 0}, {"color": "#ffffff", "pos": 0.2}]}
+	]`
+	if err := ioutil.WriteFile(filepath.Join(dir, "maps.json"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := LoadColorMaps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatal("expected two colormaps, got", names)
+	}
+
+	even, err := ColorMapByName("test-even")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := even.At(0.5); c.R < 126 || c.R > 129 || c.G != 0 || c.B < 126 || c.B > 129 {
+		t.Error("expected an rgb blend of red and blue, got", c)
+	}
+
+	keys, err := ColorMapByName("test-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keypoints are sorted by position
+	if c := keys.At(0.2); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Error("expected white at 0.2, got", c)
+	}
+
+	for _, bad := range []string{
+		`{"name": "x", "colors": ["#000000"]}`,
+		`{"name": "x", "colors": ["#000000", "nope"]}`,
+		`{"name": "x", "space": "xyz", "colors": ["#000000", "#ffffff"]}`,
+		`{"colors": ["#000000", "#ffffff"]}`,
+	} {
+		if _, err := ParseColorMaps([]byte(bad)); err == nil {
+			t.Error("expected an error parsing", bad)
+		}
+	}
+}
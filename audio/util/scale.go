@@ -0,0 +1,178 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Scale represents the scale that is used to calculate bucket indices.
+type Scale interface {
+	To(float64) float64
+	From(float64) float64
+}
+
+type melScale struct{}
+
+// MelScale is a scale defined by how humans perceive pitch differences.
+var MelScale *melScale
+
+func (s *melScale) To(val float64) float64 {
+	return 1127 * math.Log(1+val/700)
+}
+
+func (s *melScale) From(val float64) float64 {
+	return 700 * (math.Exp(val/1127.0) - 1)
+}
+
+type logScale struct{}
+
+// LogScale is a typical log2 scale.
+var LogScale *logScale
+
+func (s *logScale) To(val float64) float64 {
+	return math.Log2(1 + val)
+}
+func (s *logScale) From(val float64) float64 {
+	return math.Exp2(val) - 1
+}
+
+type linearScale struct{}
+
+// LinearScale spaces buckets evenly in Hz.
+var LinearScale *linearScale
+
+func (s *linearScale) To(val float64) float64 {
+	return val
+}
+func (s *linearScale) From(val float64) float64 {
+	return val
+}
+
+type barkScale struct{}
+
+// BarkScale is the critical band rate scale, using Traunmüller's approximation.
+var BarkScale *barkScale
+
+func (s *barkScale) To(val float64) float64 {
+	return 26.81*val/(1960+val) - 0.53
+}
+func (s *barkScale) From(val float64) float64 {
+	return 1960 * (val + 0.53) / (26.28 - val)
+}
+
+type erbScale struct{}
+
+// ERBScale is the equivalent rectangular bandwidth rate scale of Glasberg and Moore.
+var ERBScale *erbScale
+
+func (s *erbScale) To(val float64) float64 {
+	return 21.4 * math.Log10(1+0.00437*val)
+}
+func (s *erbScale) From(val float64) float64 {
+	return (math.Pow(10, val/21.4) - 1) / 0.00437
+}
+
+// OctaveBandScale is a scale on which 1/N octave bands are evenly spaced, with a band
+// centered on 1kHz as in IEC 61260. Band k is centered at 1000*2^(k/N) Hz.
+type OctaveBandScale struct {
+	N int
+}
+
+// OctaveScale is a true octave scale.
+var OctaveScale = OctaveBandScale{N: 1}
+
+// ThirdOctaveScale is the scale of the standard 1/3 octave bands.
+var ThirdOctaveScale = OctaveBandScale{N: 3}
+
+// To returns the band number of a frequency.
+func (s OctaveBandScale) To(val float64) float64 {
+	return float64(s.N) * math.Log2(val/1000)
+}
+
+// From returns the frequency of a band number.
+func (s OctaveBandScale) From(val float64) float64 {
+	return 1000 * math.Exp2(val/float64(s.N))
+}
+
+// Snap widens [@fMin, @fMax] to the nearest band edges and returns the new range along with
+// the number of bands in it. Using these for a Bucketer puts one band in each bucket.
+func (s OctaveBandScale) Snap(fMin, fMax float64) (lo, hi float64, bands int) {
+	first := math.Floor(s.To(fMin) + 0.5)
+	last := math.Ceil(s.To(fMax) - 0.5)
+	if last < first {
+		last = first
+	}
+	lo = s.From(first - 0.5)
+	hi = s.From(last + 0.5)
+	return lo, hi, int(last-first) + 1
+}
+
+var (
+	scaleLock sync.RWMutex
+	scales    = map[string]Scale{
+		"linear":       LinearScale,
+		"log":          LogScale,
+		"mel":          MelScale,
+		"bark":         BarkScale,
+		"erb":          ERBScale,
+		"octave":       OctaveScale,
+		"third-octave": ThirdOctaveScale,
+	}
+)
+
+// RegisterScale makes a scale available to ScaleByName.
+func RegisterScale(name string, s Scale) {
+	scaleLock.Lock()
+	defer scaleLock.Unlock()
+	scales[name] = s
+}
+
+// ScaleByName returns a registered scale. Besides the registered names, it understands
+// "1/N-octave" for any N.
+func ScaleByName(name string) (Scale, error) {
+	scaleLock.RLock()
+	s, ok := scales[name]
+	scaleLock.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	if strings.HasPrefix(name, "1/") && strings.HasSuffix(name, "-octave") {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "1/"), "-octave"))
+		if err == nil && n > 0 {
+			return OctaveBandScale{N: n}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown scale: %q", name)
+}
+
+// ScaleName returns the name that @s can be looked up by with ScaleByName.
+func ScaleName(s Scale) (string, bool) {
+	scaleLock.RLock()
+	defer scaleLock.RUnlock()
+	for name, r := range scales {
+		if r == s {
+			return name, true
+		}
+	}
+	if o, ok := s.(OctaveBandScale); ok && o.N > 0 {
+		return fmt.Sprintf("1/%d-octave", o.N), true
+	}
+	return "", false
+}
+
+// ScaleNames returns the names of all registered scales in order.
+func ScaleNames() []string {
+	scaleLock.RLock()
+	defer scaleLock.RUnlock()
+	names := make([]string, 0, len(scales))
+	for name := range scales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
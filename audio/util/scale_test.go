@@ -0,0 +1,59 @@
+package util
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScales(t *testing.T) {
+	for _, name := range append(ScaleNames(), "1/6-octave") {
+		s, err := ScaleByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := math.Inf(-1)
+		for _, f := range []float64{20, 100, 440, 1000, 5000, 20000} {
+			v := s.To(f)
+			if v <= last {
+				t.Errorf("%s: scale is not increasing at %vHz", name, f)
+			}
+			last = v
+			if got := s.From(v); math.Abs(got-f) > 1e-6*f {
+				t.Errorf("%s: From(To(%v)) = %v", name, f, got)
+			}
+		}
+	}
+
+	if _, err := ScaleByName("nope"); err == nil {
+		t.Error("expected unknown scale to fail")
+	}
+	if _, err := ScaleByName("1/0-octave"); err == nil {
+		t.Error("expected 1/0-octave to fail")
+	}
+
+	for _, s := range []Scale{MelScale, ThirdOctaveScale, OctaveBandScale{N: 12}} {
+		name, ok := ScaleName(s)
+		if r, err := ScaleByName(name); !ok || err != nil || r != s {
+			t.Errorf("expected %q to round trip", name)
+		}
+	}
+}
+
+func TestOctaveBands(t *testing.T) {
+	// the standard third octave bands from 25Hz to 20kHz
+	lo, hi, n := ThirdOctaveScale.Snap(25, 20000)
+	if n != 30 {
+		t.Errorf("expected 30 bands, got %d", n)
+	}
+	b, err := NewBucketer(ThirdOctaveScale, n, 4096, 48000, lo, hi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	centers := b.Centers()
+	for i, want := range map[int]float64{0: 25, 14: 630, 15: 800, 16: 1000, 29: 20000} {
+		// nominal frequencies are rounded, so allow a few percent
+		if math.Abs(centers[i]-want)/want > 0.03 {
+			t.Errorf("band %d: expected center near %v, got %v", i, want, centers[i])
+		}
+	}
+}
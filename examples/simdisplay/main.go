@@ -7,12 +7,19 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/peragwin/vuzicgo/audio"
 	"github.com/peragwin/vuzicgo/audio/fft"
 	fs "github.com/peragwin/vuzicgo/audio/sensors/freqsensor"
+	"github.com/peragwin/vuzicgo/audio/sensors/loudsensor"
+	"github.com/peragwin/vuzicgo/audio/sensors/tonesensor"
+	"github.com/peragwin/vuzicgo/audio/util"
 	"github.com/peragwin/vuzicgo/gfx/warpgrid"
 )
 
@@ -31,8 +38,55 @@ var (
 	columns = flag.Int("columns", 16, "number of cells per row")
 
 	mode = flag.Int("mode", fs.NormalMode, "which mode: 0=Normal, 1=Animate")
+
+	scale = flag.String("scale", "log",
+		"frequency scale of the buckets: "+strings.Join(util.ScaleNames(), ", ")+" or 1/N-octave")
+	fMin = flag.Float64("fmin", 32, "lowest bucketed frequency in Hz")
+	fMax = flag.Float64("fmax", 16000, "highest bucketed frequency in Hz")
+
+	multires = flag.String("multires", "",
+		"comma separated FFT sizes for multi-resolution analysis, e.g. 8192,4096,2048,1024; "+
+			"the noise gate only works with a single FFT, so it's off")
+
+	palette = flag.String("palette", "",
+		"colormap to color cells along instead of hue: "+strings.Join(util.ColorMapNames(), ", "))
+	palettes = flag.String("palettes", "", "JSON file or directory of extra colormaps to load")
+
+	presetDir = flag.String("presets", "presets", "directory of the preset library")
+	preset    = flag.String("preset", "", "preset to start with")
+	slew      = flag.Float64("slew", 0.25, "time constant in seconds of parameter changes")
+
+	scaleBand = flag.String("scale-band", "",
+		"band driver that scales the display instead of the bass, e.g. sub, low-mid or air")
+
+	playlist   = flag.String("playlist", "", "JSON or YAML playlist of presets to step through")
+	sceneState = flag.String("scene-state", "scene.json", "file that keeps the playlist position")
+
+	normalize = flag.Float64("normalize", 0,
+		"short-term loudness in LUFS to normalize the input of the sensor to, e.g. -23; off at 0")
+
+	record = flag.String("record", "", "file to record the output of the sensor to")
+	replay = flag.String("replay", "",
+		"recording to play instead of listening to the audio input")
+	replaySpeed = flag.Float64("replay-speed", 1, "how much faster than recorded to replay")
+	replayLoop  = flag.Bool("replay-loop", false, "start the recording over when it ends")
 )
 
+func parseSizes(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var sizes []int
+	for _, f := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
 func initGfx(done chan struct{}) *warpgrid.Grid {
 	runtime.LockOSThread()
 
@@ -51,6 +105,23 @@ func initGfx(done chan struct{}) *warpgrid.Grid {
 func main() {
 	flag.Parse()
 
+	bucketScale, err := util.ScaleByName(*scale)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *palettes != "" {
+		if _, err := util.LoadColorMaps(*palettes); err != nil {
+			log.Fatal("error loading colormaps:", err)
+		}
+	}
+	var colorMap *util.ColorMap
+	if *palette != "" {
+		if colorMap, err = util.ColorMapByName(*palette); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	render := make(chan struct{})
 	defer close(render)
 	done := make(chan struct{})
@@ -60,29 +131,66 @@ func main() {
 	// because of the syscall that binds it to the main thread.
 	g := initGfx(done)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	var sources []chan []float64
+	if *replay == "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-	source, errc := audio.NewSource(ctx, &audio.Config{
-		BlockSize:  frameSize,
-		SampleRate: sampleRate,
-		Channels:   1,
-	})
+		source, errc := audio.NewSource(ctx, &audio.Config{
+			BlockSize:  frameSize,
+			SampleRate: sampleRate,
+			Channels:   1,
+		})
 
-	// watch for errors
-	go func() {
-		defer close(done)
-		err := <-errc
+		// watch for errors
+		go func() {
+			defer close(done)
+			err := <-errc
+			log.Fatal(err)
+		}()
+
+		source64 := audio.Buffer(done, source)
+		sources = audio.Tee(done, source64, 3)
+	} else {
+		// nothing is heard while a recording plays
+		for i := 0; i < 3; i++ {
+			sources = append(sources, make(chan []float64))
+		}
+	}
+
+	loudness := loudsensor.NewLoudnessSensor(sampleRate)
+	loudOut := loudness.Process(done, sources[1])
+	if *normalize != 0 {
+		sources[0] = loudsensor.NewNormalizer(loudness, *normalize).Process(done, sources[0])
+	}
+
+	// tones to watch for are added through the graphql API
+	tones, err := tonesensor.NewToneSensor(sampleRate, nil)
+	if err != nil {
 		log.Fatal(err)
-	}()
+	}
+	toneOut := tones.Process(done, sources[2])
 
-	source64 := audio.Buffer(done, source)
+	sizes, err := parseSizes(*multires)
+	if err != nil {
+		log.Fatal("bad -multires: ", err)
+	}
+	extensions := []fs.Extension{loudness, tones}
 
-	fftProc := fft.NewFFTProcessor(sampleRate, frameSize)
-	fftOut := fftProc.Process(done, source64)
+	// the gate tracks the floor of a single spectrum, which multi-resolution analysis
+	// doesn't have
+	var gate *fft.NoiseGateProcessor
+	if len(sizes) == 0 {
+		if gate, err = fft.NewNoiseGateProcessor(fft.DefaultNoiseGateParams); err != nil {
+			log.Fatal(err)
+		}
+		extensions = append(extensions, gate)
+	}
 
-	specProc := new(fft.PowerSpectrumProcessor)
-	specOut := specProc.Process(done, fftOut)
+	presets, err := fs.NewPresetLibrary(*presetDir)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	fs.DefaultParameters.Mode = *mode
 	fs.DefaultParameters.Period = 3 * *columns / 2
@@ -90,17 +198,134 @@ func main() {
 		Columns:    *columns,
 		Buckets:    *buckets,
 		SampleRate: sampleRate,
+		// a frame for each block from the source, with either analysis
+		FrameRate:  float64(sampleRate) / frameSize,
 		Parameters: fs.DefaultParameters,
+		Scale:      *scale,
+		FMin:       *fMin,
+		FMax:       *fMax,
+		Extensions: extensions,
+		Presets:    presets,
+		SlewTime:   *slew,
 	})
-	fsOut := f.Process(done, specOut)
-	// this output isn't needed here so throw it away
+	if *preset != "" {
+		if err := f.RecallPreset(*preset, 0); err != nil {
+			log.Fatal(err)
+		}
+	}
+	f.WatchPresets(done, time.Second)
+
+	if *playlist != "" {
+		pl, err := fs.LoadPlaylist(*playlist)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sched, err := fs.NewScheduler(pl, f.RecallPreset, *sceneState)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := sched.Start(); err != nil {
+			log.Fatal(err)
+		}
+		f.SetScheduler(sched)
+		sched.Run(done, 100*time.Millisecond)
+	}
+
+	// loudness and tones drive the modulators
 	go func() {
-		for {
-			<-fsOut
+		for l := range loudOut {
+			f.Modulators().SetFeature("momentary", l.Momentary)
+			f.Modulators().SetFeature("aWeighted", l.AWeighted)
+		}
+	}()
+	go func() {
+		for ev := range toneOut {
+			log.Printf("tone %s on=%v level=%.1fdB at %v", ev.Target, ev.On, ev.Level, ev.Time)
+			if ev.On {
+				f.Modulators().Trigger(ev.Target)
+			}
 		}
 	}()
 
-	rndr := newRenderer(*columns, fs.DefaultParameters, f)
+	var fsOut chan *fs.Drivers
+	if *replay != "" {
+		frames, err := fs.LoadRecording(*replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(frames) > 0 {
+			// the renderer indexes the rows by column in AnimateMode
+			drv := frames[0].Drivers
+			if len(drv.Diff) != *buckets || len(drv.Amplitude) != *columns {
+				log.Fatalf("%s was recorded with %d buckets and %d columns",
+					*replay, len(drv.Diff), len(drv.Amplitude))
+			}
+		}
+		log.Printf("replaying %d frames from %s", len(frames), *replay)
+		player := fs.NewReplayer(frames)
+		player.Speed, player.Loop = *replaySpeed, *replayLoop
+		fsOut = player.Play(done)
+	} else if len(sizes) > 0 {
+		mr, err := fft.NewMultiResolutionProcessor(util.BucketerConfig{
+			Scale:      bucketScale,
+			Buckets:    *buckets,
+			SampleRate: sampleRate,
+			FMin:       *fMin,
+			FMax:       *fMax,
+		}, sizes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		edges := mr.Edges()
+		for i := 0; i < mr.Buckets(); i++ {
+			log.Printf("bucket %d: %.0f-%.0fHz fft=%d latency=%v",
+				i, edges[i], edges[i+1], mr.Size(i), mr.Latency(i))
+		}
+		fsOut = f.ProcessBuckets(done, mr.Process(done, sources[0]))
+	} else {
+		fftProc := fft.NewFFTProcessor(sampleRate, frameSize)
+		fftOut := fftProc.Process(done, sources[0])
+
+		specProc := new(fft.PowerSpectrumProcessor)
+		specOut := specProc.Process(done, fftOut)
+
+		fsOut = f.Process(done, gate.Process(done, specOut))
+	}
+	rndr := newRenderer(*columns, f, colorMap)
+	if *scaleBand != "" {
+		if _, ok := f.Bands().Value(*scaleBand); !ok {
+			log.Fatalf("no band driver called %q", *scaleBand)
+		}
+		rndr.scaleBand = *scaleBand
+	}
+	if *record != "" {
+		file, err := os.Create(*record)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		rec, err := fs.NewRecorder(file, fs.AllFields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rec.Flush()
+		fsOut = rec.Process(done, fsOut)
+	}
+
+	// remote clients can follow the sensor at /api/v1/drivers
+	streamer := fs.NewStreamer()
+	fsOut = streamer.Process(done, fsOut)
+
+	// the renderer draws whichever frame is the latest when the display asks for one
+	go func() {
+		for drv := range fsOut {
+			if *replay != "" {
+				// the sensor doesn't process a replay, so its parameters are stepped here
+				f.StepParameters()
+			}
+			rndr.update(drv)
+		}
+	}()
 	frames := rndr.Render(done, render)
 
 	g.SetRenderFunc(func(g *warpgrid.Grid) {
@@ -146,6 +371,8 @@ func main() {
 			json.NewEncoder(w).Encode(res)
 		})
 
+		http.Handle("/api/v1/drivers", streamer)
+
 		http.ListenAndServe(":8080", nil)
 	}()
 
@@ -6,17 +6,25 @@ import (
 	"image"
 	"image/color"
 	"math"
+	"sync/atomic"
 	"time"
 
 	colorful "github.com/lucasb-eyer/go-colorful"
 	fs "github.com/peragwin/vuzicgo/audio/sensors/freqsensor"
+	"github.com/peragwin/vuzicgo/audio/util"
 )
 
 type renderer struct {
 	src     *fs.FrequencySensor
 	columns int
+	// rows is the number of rows of the display, which stays the same when the sensor
+	// changes its number of buckets
 	rows    int
-	params  *fs.Parameters
+	palette *util.ColorMap
+	// scaleBand is the band driver that scales the display, instead of the bass
+	scaleBand string
+	// frame is the latest output of the sensor
+	frame atomic.Value // *fs.Drivers
 
 	renderCount int
 	lastRender  time.Time
@@ -26,10 +34,11 @@ type renderer struct {
 	scale   float32
 }
 
-func newRenderer(columns int, params *fs.Parameters, src *fs.FrequencySensor) *renderer {
+// newRenderer creates a renderer that colors cells by hue, or along @palette if it's not nil.
+func newRenderer(columns int, src *fs.FrequencySensor, palette *util.ColorMap) *renderer {
 	display := image.NewRGBA(image.Rect(0, 0, columns, src.Buckets))
 	return &renderer{
-		params:  params,
+		palette: palette,
 		columns: columns,
 		rows:    src.Buckets,
 		src:     src,
@@ -38,6 +47,11 @@ func newRenderer(columns int, params *fs.Parameters, src *fs.FrequencySensor) *r
 	}
 }
 
+// update makes @drv the frame that's rendered next.
+func (r *renderer) update(drv *fs.Drivers) {
+	r.frame.Store(drv)
+}
+
 type renderValues struct {
 	img   *image.RGBA
 	warp  []float32
@@ -68,18 +82,25 @@ func (r *renderer) Render(done, request chan struct{}) chan *renderValues {
 }
 
 func (r *renderer) render() {
+	drv, _ := r.frame.Load().(*fs.Drivers)
+	if drv == nil {
+		return
+	}
+	// use the same parameters for the whole frame, including their modulations
+	params := r.src.FrameParameters()
+
 	r.renderCount++
-	if r.params.Debug && r.renderCount%100 == 0 {
+	if params.Debug && r.renderCount%100 == 0 {
 		diff := time.Now().Sub(r.lastRender)
 		m := map[string]interface{}{
 			"fps":  diff / 100.0,
-			"amp":  r.src.Amplitude[0],
-			"pha":  r.src.Energy,
-			"diff": r.src.Diff,
+			"amp":  drv.Amplitude[0],
+			"pha":  drv.Energy,
+			"diff": drv.Diff,
 		}
 		bs, err := json.Marshal(m) //Indent(m, "", "  ")
 		if err != nil {
-			fmt.Printf("%#v", r.src.Drivers)
+			fmt.Printf("%#v", drv)
 			panic(err)
 		}
 		fmt.Println(string(bs))
@@ -87,38 +108,54 @@ func (r *renderer) render() {
 	}
 	hl := r.columns / 2
 	for i := 0; i < hl; i++ {
-		col := r.renderColumn(i)
+		col := r.renderColumn(drv, params, i)
 		for j, c := range col {
 			r.display.SetRGBA(hl+i, r.rows-j-1, c)
 			r.display.SetRGBA(hl-1-i, r.rows-j-1, c)
 		}
 	}
-	for i, d := range r.src.Diff {
-		r.warp[i] = float32(r.params.WarpOffset + r.params.WarpScale*math.Abs(d))
+	for i := range r.warp {
+		d := drv.Diff[r.bucket(drv, i)]
+		r.warp[i] = float32(params.WarpOffset + params.WarpScale*math.Abs(d))
+	}
+	bass := drv.Bass
+	if r.scaleBand != "" {
+		bass = drv.Bands[r.scaleBand]
 	}
-	r.scale = float32(1 + r.params.Scale*r.src.Bass)
+	r.scale = float32(1 + params.Scale*bass)
 }
 
-func (r *renderer) renderColumn(col int) []color.RGBA {
+func (r *renderer) renderColumn(drv *fs.Drivers, params *fs.Parameters, col int) []color.RGBA {
 
-	amp := r.src.Amplitude[0]
-	if r.params.Mode == fs.AnimateMode {
-		amp = r.src.Amplitude[col]
+	amp := drv.Amplitude[0]
+	if params.Mode == fs.AnimateMode {
+		amp = drv.Amplitude[col]
 	}
-	phase := r.src.Energy
-	ws := 2.0 * math.Pi / float64(r.params.Period)
+	phase := drv.Energy
+	ws := 2.0 * math.Pi / float64(params.Period)
 	phi := ws * float64(col)
 
 	colors := make([]color.RGBA, r.rows)
 
-	for i, ph := range phase {
-		//colors[i] = getRGB(d.params, amp[i], ph, phi)
-		colors[i] = getHSV(r.params, amp[i], ph, phi)
+	for i := range colors {
+		b := r.bucket(drv, i)
+		//colors[i] = getRGB(d.params, amp[b], phase[b], phi)
+		if r.palette != nil {
+			colors[i] = getPalette(params, r.palette, amp[b], phase[b], phi)
+		} else {
+			colors[i] = getHSV(params, amp[b], phase[b], phi)
+		}
 	}
 
 	return colors
 }
 
+// bucket returns the bucket of @drv that's shown in @row, so that the display keeps its
+// rows when the buckets are changed while it runs.
+func (r *renderer) bucket(drv *fs.Drivers, row int) int {
+	return row * len(drv.Diff) / r.rows
+}
+
 func getHSV(params *fs.Parameters, amp, ph, phi float64) color.RGBA {
 	br := params.Brightness
 	gbr := params.GlobalBrightness
@@ -134,6 +171,26 @@ func getHSV(params *fs.Parameters, amp, ph, phi float64) color.RGBA {
 	return color.RGBA{r, g, b, 255}
 }
 
+// getPalette is like getHSV but picks the hue from a position along @palette. The phase is
+// mirrored so the palette's ends don't meet in a seam.
+func getPalette(params *fs.Parameters, palette *util.ColorMap, amp, ph, phi float64) color.RGBA {
+	br := params.Brightness
+	gbr := params.GlobalBrightness
+
+	t := math.Mod((ph+phi)/(2*math.Pi), 1)
+	if t < 0 {
+		t++
+	}
+	t = 1 - math.Abs(2*t-1)
+
+	hue, sat, val := palette.Color(t).Hsv()
+	sat *= fs.Sigmoid(br - 2 + amp)
+	val *= fs.Sigmoid(gbr/255*(1+amp) - 2)
+
+	r, g, b := colorful.Hsv(hue, sat, val).RGB255()
+	return color.RGBA{r, g, b, 255}
+}
+
 func getRGB(params *fs.Parameters, amp, ph, phi float64) color.RGBA {
 	br := params.Brightness
 	gbr := params.GlobalBrightness
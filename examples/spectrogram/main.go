@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 
 	"github.com/go-gl/gl/v2.1/gl"
@@ -26,7 +27,24 @@ const (
 	textureMode = gl.LINEAR
 )
 
+var (
+	palette  = flag.String("palette", "spectral", "name of the colormap to draw with")
+	palettes = flag.String("palettes", "", "JSON file or directory of extra colormaps to load")
+)
+
 func main() {
+	flag.Parse()
+
+	if *palettes != "" {
+		if _, err := util.LoadColorMaps(*palettes); err != nil {
+			log.Fatal("error loading colormaps:", err)
+		}
+	}
+	cm, err := util.ColorMapByName(*palette)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -138,9 +156,9 @@ func main() {
 	// 	}
 	// 	return i - frameSize/2
 	// }
-	colorMap := util.NewColorMap(256)
+	colorMap := cm.Table(256)
 
-	_, err := grid.NewGrid(done, &grid.Config{
+	_, err = grid.NewGrid(done, &grid.Config{
 		Rows: frameSize / 2, Columns: rows,
 		Width: width, Height: height,
 		Title:       "Spectrogram Display",
@@ -173,8 +191,6 @@ func main() {
 						s = 0
 					}
 					c := colorMap[s]
-					// r, _g, b := colorMap.GetInterpolatedColorFor(scaled).RGB255()
-					// c := color.RGBA{r, _g, b, 255}
 					g.SetColor(i, frameSize/2-1-j, c)
 				}
 			}